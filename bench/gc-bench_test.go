@@ -0,0 +1,56 @@
+package bench
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ahuigo/gofnext"
+)
+
+type smallInfo struct {
+	ID   int
+	Name string
+}
+
+func getSmallInfo(id int) smallInfo {
+	return smallInfo{ID: id, Name: "Alex"}
+}
+
+const gcEntries = 1_000_000
+
+// benchmarkGC fills the cache with many small entries, then measures the time of a full GC.
+func benchmarkGC(b *testing.B, f func(int) smallInfo) {
+	b.Helper()
+	for i := 0; i < gcEntries; i++ {
+		f(i)
+	}
+	runtime.GC()
+	b.ResetTimer()
+	var total time.Duration
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		runtime.GC()
+		total += time.Since(start)
+	}
+	b.ReportMetric(float64(total.Nanoseconds())/float64(b.N), "ns/gc")
+	runtime.KeepAlive(f)
+}
+
+// go test -bench="GC$" -benchmem .
+func BenchmarkMemCacheGC(b *testing.B) {
+	benchmarkGC(b, gofnext.CacheFn1(getSmallInfo))
+}
+
+func BenchmarkArenaCacheGC(b *testing.B) {
+	benchmarkGC(b, gofnext.CacheFn1(getSmallInfo, &gofnext.Config{
+		CacheMap: gofnext.NewCacheArena(256 << 20),
+	}))
+}
+
+func BenchmarkGetDataWithArenaCache(b *testing.B) {
+	getUserWithArenaCache := gofnext.CacheFn1(getUser, &gofnext.Config{
+		CacheMap: gofnext.NewCacheArena(64 << 20),
+	})
+	benchmark(b, getUserWithArenaCache)
+}
@@ -0,0 +1,253 @@
+package gofnext
+
+import (
+	"context"
+	"encoding/json"
+	"hash"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ahuigo/gofnext/serial"
+	"github.com/go-redis/redis"
+)
+
+/*
+cacheInvalidator broadcasts cache deletions to every instance via redis pub/sub.
+Each instance attaches its in-memory CacheMaps(e.g. the l1 of NewCacheTiered) by name,
+deletions and purges are applied locally and published to other instances.
+
+Keys are derived from the function's parameters in the same way as the decorator does with default key options:
+by HashKeyFunc if the attached CacheMap has one(e.g. NewCacheTiered), otherwise by the raw(or dumped) parameters.
+Functions cached with Config.HashKeyDigest need the same digest(see SetHashKeyDigest).
+*/
+type cacheInvalidator struct {
+	mu          sync.RWMutex
+	redisClient redis.UniversalClient
+	pubsub      *redis.PubSub
+	channel     string
+	instanceId  string
+	cacheMaps   map[string][]CacheMapDeleter
+	keyDigest   func() hash.Hash
+	closed      chan struct{}
+	done        chan struct{}
+}
+
+type invalidateMessage struct {
+	Instance string `json:"instance"`
+	Op       string `json:"op"`
+	Name     string `json:"name"`
+	Key      string `json:"key,omitempty"`    // key derived by HashKeyFunc
+	Raw      string `json:"raw,omitempty"`    // dump of the raw key(parameters without context)
+	Digest   string `json:"digest,omitempty"` // digest of the raw key(see Config.HashKeyDigest)
+}
+
+type hashKeyFuncer interface {
+	HashKeyFunc(key ...any) []byte
+}
+
+const invalidatorPingInterval = 30 * time.Second
+
+const (
+	invalidateOpDelete = "del"
+	invalidateOpClear  = "clear"
+)
+
+// NewCacheInvalidatorRedis subscribes the channel with redisClient(usually the same client used by NewCacheRedis)
+func NewCacheInvalidatorRedis(redisClient redis.UniversalClient, channel string) *cacheInvalidator {
+	if channel == "" {
+		panic("NewCacheInvalidatorRedis: channel cannot be empty")
+	}
+	m := &cacheInvalidator{
+		redisClient: redisClient,
+		channel:     "_gofnext:" + channel,
+		instanceId:  strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(rand.Int63(), 36),
+		cacheMaps:   map[string][]CacheMapDeleter{},
+		closed:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	m.pubsub = redisClient.Subscribe(m.channel)
+	go m.receive()
+	return m
+}
+
+// Attach the CacheMap with name, the CacheMap must implement CacheMapDeleter.
+func (m *cacheInvalidator) Attach(name string, cacheMap CacheMap) *cacheInvalidator {
+	deleter, ok := cacheMap.(CacheMapDeleter)
+	if !ok {
+		panic("cacheInvalidator.Attach: cacheMap should implement CacheMapDeleter")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheMaps[name] = append(m.cacheMaps[name], deleter)
+	return m
+}
+
+// SetHashKeyDigest digests keys like the decorator with the same Config.HashKeyDigest
+func (m *cacheInvalidator) SetHashKeyDigest(digest func() hash.Hash) *cacheInvalidator {
+	m.keyDigest = digest
+	return m
+}
+
+// Delete the cache of function's parameters from every instance's CacheMaps attached with name.
+func (m *cacheInvalidator) Delete(name string, args ...any) error {
+	key, err := dumpHashKeyErr(args...)
+	if err != nil {
+		return err
+	}
+	rawKey := rawHashKey(args)
+	raw, err := serial.BytesErr(rawKey, false)
+	if err != nil {
+		return err
+	}
+	msg := invalidateMessage{Op: invalidateOpDelete, Name: name, Key: string(digestKey(m.keyDigest, key)), Raw: string(raw)}
+	if m.keyDigest != nil {
+		if msg.Digest, err = digestRawKey(m.keyDigest, rawKey, false); err != nil {
+			return err
+		}
+	}
+	m.apply(msg)
+	return m.publish(msg)
+}
+
+// rawHashKey returns the key of a CacheMap without HashKeyFunc(see cachedFn.rawKey)
+func rawHashKey(args []any) any {
+	if len(args) > 0 {
+		if _, hasCtx := args[0].(context.Context); hasCtx {
+			args = args[1:]
+		}
+	}
+	switch len(args) {
+	case 1:
+		return args[0]
+	case 2:
+		return [2]any{args[0], args[1]}
+	case 3:
+		return [3]any{args[0], args[1], args[2]}
+	default:
+		return 0
+	}
+}
+
+// Clear all caches from every instance's CacheMaps attached with name.
+func (m *cacheInvalidator) Clear(name string) error {
+	m.apply(invalidateMessage{Op: invalidateOpClear, Name: name})
+	return m.publish(invalidateMessage{Op: invalidateOpClear, Name: name})
+}
+
+// Close stops receiving messages.
+func (m *cacheInvalidator) Close() error {
+	select {
+	case <-m.closed:
+		return nil
+	default:
+	}
+	close(m.closed)
+	err := m.pubsub.Close()
+	<-m.done
+	return err
+}
+
+func (m *cacheInvalidator) publish(msg invalidateMessage) error {
+	msg.Instance = m.instanceId
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return m.redisClient.Publish(m.channel, buf).Err()
+}
+
+func (m *cacheInvalidator) apply(msg invalidateMessage) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, cacheMap := range m.cacheMaps[msg.Name] {
+		switch msg.Op {
+		case invalidateOpDelete:
+			m.delete(cacheMap, msg)
+		case invalidateOpClear:
+			cacheMap.Clear()
+		}
+	}
+}
+
+// delete the key from cacheMap. The key derived by HashKeyFunc is always deleted(e.g. from the l1 of NewCacheTiered),
+// and the raw key is tried in both native and dumped forms for CacheMaps without HashKeyFunc.
+func (m *cacheInvalidator) delete(cacheMap CacheMapDeleter, msg invalidateMessage) {
+	cacheMap.Delete(msg.Key)
+	if _, ok := cacheMap.(hashKeyFuncer); ok || msg.Raw == "" {
+		return
+	}
+	cacheMap.Delete(msg.Raw)
+	if msg.Digest != "" {
+		cacheMap.Delete(msg.Digest)
+	}
+	var raw any
+	if err := serial.Load([]byte(msg.Raw), &raw); err == nil && isHashableKey(raw, false) {
+		cacheMap.Delete(raw)
+	}
+}
+
+// clearAll purges all attached CacheMaps(messages may be lost while reconnecting)
+func (m *cacheInvalidator) clearAll() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, cacheMaps := range m.cacheMaps {
+		for _, cacheMap := range cacheMaps {
+			cacheMap.Clear()
+		}
+	}
+}
+
+func (m *cacheInvalidator) receive() {
+	defer close(m.done)
+	subscribed := false
+	errCount := 0
+	for {
+		msg, err := m.pubsub.ReceiveTimeout(invalidatorPingInterval)
+		if err != nil {
+			select {
+			case <-m.closed:
+				return
+			default:
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// check the connection's health, pubsub reconnects if ping fails
+				_ = m.pubsub.Ping()
+				continue
+			}
+			// pubsub reconnects on next Receive
+			errCount++
+			backoff := time.Duration(errCount) * 10 * time.Millisecond
+			if backoff > time.Second {
+				backoff = time.Second
+			}
+			slogger.Error("gofnext.cacheInvalidator: receive", "err", err.Error())
+			time.Sleep(backoff)
+			continue
+		}
+		errCount = 0
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind != "subscribe" {
+				break
+			}
+			if subscribed {
+				// resubscribed after reconnecting
+				m.clearAll()
+			}
+			subscribed = true
+		case *redis.Message:
+			data := invalidateMessage{}
+			if err := json.Unmarshal([]byte(msg.Payload), &data); err != nil {
+				slogger.Error("gofnext.cacheInvalidator: decode", "err", err.Error())
+				break
+			}
+			if data.Instance != m.instanceId {
+				m.apply(data)
+			}
+		}
+	}
+}
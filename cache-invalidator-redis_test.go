@@ -0,0 +1,135 @@
+package gofnext
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"unsafe"
+
+	"github.com/ahuigo/gofnext/serial"
+)
+
+func TestCacheInvalidatorRedis(t *testing.T) {
+	stub := newRedisStub(t)
+	channel := "_gofnext:invalidate"
+
+	// two instances share the same redis
+	l1a := NewCacheLru(100)
+	l1b := NewCacheLru(100)
+	busA := NewCacheInvalidatorRedis(stub.Client(), "invalidate").Attach("user", l1a)
+	busB := NewCacheInvalidatorRedis(stub.Client(), "invalidate").Attach("user", l1b)
+	defer busA.Close()
+	defer busB.Close()
+	waitFor(t, func() bool { return stub.Subscribers(channel) == 2 })
+
+	hasCache := func(m CacheMap, key any) bool {
+		_, hasCache, _, _ := m.Load(key)
+		return hasCache
+	}
+	// maps used directly are keyed by the raw arguments
+	executeCount := 0
+	getUser := func(id int) int {
+		executeCount++
+		return id
+	}
+	getUserA := CacheFn1(getUser, &Config{CacheMap: l1a})
+	getUserB := CacheFn1(getUser, &Config{CacheMap: l1b})
+	for _, fn := range []func(int) int{getUserA, getUserB} {
+		fn(1)
+		fn(2)
+	}
+	AssertEqual(t, executeCount, 4)
+
+	// 1. delete key from every instance
+	if err := busA.Delete("user", 1); err != nil {
+		t.Fatal(err)
+	}
+	if hasCache(l1a, 1) {
+		t.Fatal("local cache should be deleted")
+	}
+	waitFor(t, func() bool { return !hasCache(l1b, 1) })
+	if !hasCache(l1b, 2) {
+		t.Fatal("other keys should be kept")
+	}
+	getUserB(1)
+	getUserB(2)
+	AssertEqual(t, executeCount, 5)
+
+	// 2. clear every instance
+	if err := busB.Clear("user"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !hasCache(l1a, 2) })
+
+	// 3. purge after reconnecting(messages may be lost while disconnected)
+	l1b.Store(1, 1, nil)
+	stub.DropConns()
+	waitFor(t, func() bool { return !hasCache(l1b, 1) })
+	waitFor(t, func() bool { return stub.Subscribers(channel) == 2 })
+
+	// 4. still works after reconnecting
+	l1b.Store(1, 1, nil)
+	if err := busA.Delete("user", 1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !hasCache(l1b, 1) })
+}
+
+func TestCacheInvalidatorRedis_Keys(t *testing.T) {
+	stub := newRedisStub(t)
+	channel := "_gofnext:invalidate-keys"
+
+	// 1. unhashable arguments are dumped, the l1 of tiered maps is keyed by HashKeyFunc
+	lru := NewCacheLru(100)
+	l1 := NewCacheLru(100)
+	tiered := NewCacheTiered(l1, NewCacheRedis("invalidate-keys").SetRedisAddr(stub.Addr()))
+	bus := NewCacheInvalidatorRedis(stub.Client(), "invalidate-keys").Attach("lru", lru).Attach("tiered", l1)
+	defer bus.Close()
+	waitFor(t, func() bool { return stub.Subscribers(channel) == 1 })
+
+	executeCount := 0
+	sum := func(nums []int, n int) int {
+		executeCount++
+		return len(nums) + n
+	}
+	sumLru := CacheFn2(sum, &Config{CacheMap: lru})
+	sumTiered := CacheFn2(sum, &Config{CacheMap: tiered})
+	sumLru([]int{1}, 2)
+	sumTiered([]int{1}, 2)
+	AssertEqual(t, executeCount, 2)
+
+	if err := bus.Delete("lru", []int{1}, 2); err != nil {
+		t.Fatal(err)
+	}
+	sumLru([]int{1}, 2)
+	AssertEqual(t, executeCount, 3)
+
+	if err := bus.Delete("tiered", []int{1}, 2); err != nil {
+		t.Fatal(err)
+	}
+	_, hasCache, _, _ := l1.Load(string(tiered.HashKeyFunc([]int{1}, 2)))
+	if hasCache {
+		t.Fatal("l1 should be deleted")
+	}
+
+	// 2. keys digested by Config.HashKeyDigest
+	bus.SetHashKeyDigest(sha256.New)
+	sumLru = CacheFn2(sum, &Config{CacheMap: lru, HashKeyDigest: sha256.New})
+	sumTiered = CacheFn2(sum, &Config{CacheMap: tiered, HashKeyDigest: sha256.New})
+	sumLru([]int{1}, 2)
+	sumTiered([]int{1}, 2)
+	AssertEqual(t, executeCount, 5)
+	if err := bus.Delete("lru", []int{1}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Delete("tiered", []int{1}, 2); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, lru.list.Len(), 0)
+	AssertEqual(t, l1.list.Len(), 0)
+
+	// 3. arguments which can't be dumped are rejected
+	if err := bus.Delete("lru", unsafe.Pointer(nil)); !errors.Is(err, serial.ErrUnsupportedKind) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
@@ -0,0 +1,337 @@
+package gofnext
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/ahuigo/gofnext/serial"
+)
+
+/*
+arenaCacheMap stores marshaled entries in large pre-allocated byte ring buffers.
+The index only maps uint64 hashes to uint32 offsets, so it contains no pointers
+and the GC does not need to scan the cached values (similar to bigcache/freecache).
+
+Entry layout in the ring buffer:
+
+	size(4) | hash(8) | createdAt(8) | keyLen(4) | errLen(4) | flags(1) | codecLen(1) | schemaLen(1) | fingerprintLen(1) | key | err | codec | schema | fingerprint | data
+
+The key starts with its kind(arenaKeyString or arenaKeyDump).
+*/
+const (
+	arenaShardCount   = 32
+	arenaHeaderSize   = 32
+	arenaFlagHasErr   = 1
+	arenaMinShardSize = 1024
+	// kinds of key bytes
+	arenaKeyString = 's'
+	arenaKeyDump   = 'd'
+)
+
+type arenaShard struct {
+	mu    sync.RWMutex
+	index map[uint64]uint32
+	buf   []byte
+	head  int // offset of the oldest entry
+	tail  int // offset of the next write
+	used  int // bytes used by entries and wrap padding
+}
+
+type arenaCacheMap struct {
+	shards   [arenaShardCount]*arenaShard
+	ttl      time.Duration
+	errTtl   time.Duration
+	reuseTtl time.Duration
+	codec    Codec
+	schema   string
+}
+
+// NewCacheArena creates a CacheMap backed by byte ring buffers of maxBytes in total.
+// When the buffers are full, the oldest entries are overwritten.
+func NewCacheArena(maxBytes int) *arenaCacheMap {
+	shardSize := maxBytes / arenaShardCount
+	if shardSize < arenaMinShardSize {
+		shardSize = arenaMinShardSize
+	}
+	m := &arenaCacheMap{}
+	for i := range m.shards {
+		m.shards[i] = &arenaShard{
+			index: map[uint64]uint32{},
+			buf:   make([]byte, shardSize),
+		}
+	}
+	return m
+}
+
+// keyBytes prefixes the key with its kind, so that string "1" and int 1 are different keys
+func (m *arenaCacheMap) keyBytes(key any) ([]byte, error) {
+	if s, ok := key.(string); ok {
+		return append([]byte{arenaKeyString}, s...), nil
+	}
+	data, err := serial.BytesErr(key, false)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{arenaKeyDump}, data...), nil
+}
+
+// BackendKey returns the bytes of key stored in the arena(empty if key can't be dumped)
+func (m *arenaCacheMap) BackendKey(key any) string {
+	kb, _ := m.keyBytes(key)
+	return string(kb)
+}
+
+func (m *arenaCacheMap) shard(hash uint64) *arenaShard {
+	return m.shards[hash%arenaShardCount]
+}
+
+func arenaHash(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
+
+func (m *arenaCacheMap) Store(key, value any, err0 error) {
+	encoded, err := marshalValue(key, value, m.codec, m.schema)
+	if err != nil {
+		slogger.Error("gofnext.arenaCacheMap: marshal", "err", err.Error())
+		return
+	}
+	if len(encoded.codec) > 255 || len(encoded.schema) > 255 || len(encoded.fingerprint) > 255 {
+		slogger.Error("gofnext.arenaCacheMap: codec, schema or fingerprint is longer than 255 bytes", "codec", encoded.codec)
+		return
+	}
+	kb, err := m.keyBytes(key)
+	if err != nil {
+		slogger.Error("gofnext.arenaCacheMap: key", "err", err.Error())
+		return
+	}
+	var eb []byte
+	if err0 != nil {
+		eb = marshalError(err0)
+	}
+	hash := arenaHash(kb)
+
+	size := arenaHeaderSize + len(kb) + len(eb) + len(encoded.codec) + len(encoded.schema) + len(encoded.fingerprint) + len(encoded.data)
+	entry := make([]byte, size)
+	binary.LittleEndian.PutUint32(entry[0:], uint32(size))
+	binary.LittleEndian.PutUint64(entry[4:], hash)
+	binary.LittleEndian.PutUint64(entry[12:], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint32(entry[20:], uint32(len(kb)))
+	binary.LittleEndian.PutUint32(entry[24:], uint32(len(eb)))
+	if err0 != nil {
+		entry[28] = arenaFlagHasErr
+	}
+	entry[29] = byte(len(encoded.codec))
+	entry[30] = byte(len(encoded.schema))
+	entry[31] = byte(len(encoded.fingerprint))
+	n := arenaHeaderSize
+	n += copy(entry[n:], kb)
+	n += copy(entry[n:], eb)
+	n += copy(entry[n:], encoded.codec)
+	n += copy(entry[n:], encoded.schema)
+	n += copy(entry[n:], encoded.fingerprint)
+	copy(entry[n:], encoded.data)
+
+	s := m.shard(hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.push(hash, entry) {
+		slogger.Error("gofnext.arenaCacheMap: entry is larger than shard", "size", size)
+	}
+}
+
+// Load returns the error of the key if it can't be dumped(e.g. serial.ErrUnsupportedKind), as a cache miss
+func (m *arenaCacheMap) Load(key any) (value any, hasCache, alive bool, err error) {
+	kb, err := m.keyBytes(key)
+	if err != nil {
+		return nil, false, false, err
+	}
+	hash := arenaHash(kb)
+	s := m.shard(hash)
+
+	s.mu.RLock()
+	offset, ok := s.index[hash]
+	if !ok {
+		s.mu.RUnlock()
+		return
+	}
+	entry := s.buf[offset:]
+	keyLen := int(binary.LittleEndian.Uint32(entry[20:]))
+	errLen := int(binary.LittleEndian.Uint32(entry[24:]))
+	if string(entry[arenaHeaderSize:arenaHeaderSize+keyLen]) != string(kb) {
+		// hash collision
+		s.mu.RUnlock()
+		return
+	}
+	size := int(binary.LittleEndian.Uint32(entry[0:]))
+	createdAt := time.Unix(0, int64(binary.LittleEndian.Uint64(entry[12:])))
+	hasErr := entry[28]&arenaFlagHasErr != 0
+	codecStart := arenaHeaderSize + keyLen + errLen
+	schemaStart := codecStart + int(entry[29])
+	fingerprintStart := schemaStart + int(entry[30])
+	dataStart := fingerprintStart + int(entry[31])
+	data := make([]byte, size-dataStart)
+	copy(data, entry[dataStart:size])
+	value = &encodedValue{
+		data:        data,
+		codec:       string(entry[codecStart:schemaStart]),
+		schema:      string(entry[schemaStart:fingerprintStart]),
+		fingerprint: []byte(string(entry[fingerprintStart:dataStart])),
+		local:       m.codec,
+		key:         codecKey(key),
+	}
+	if hasErr {
+		err = unmarshalError(entry[arenaHeaderSize+keyLen : codecStart])
+	}
+	s.mu.RUnlock()
+
+	if (m.ttl > 0 && time.Since(createdAt) > m.ttl) ||
+		(hasErr && m.errTtl >= 0 && time.Since(createdAt) > m.errTtl) {
+		if m.reuseTtl > 0 && time.Since(createdAt) < m.reuseTtl+m.ttl {
+			// 1. cache is within reuse ttl
+			return value, true, false, err
+		} else {
+			// 2. cache is not valid
+			s.mu.Lock()
+			if s.index[hash] == offset {
+				delete(s.index, hash)
+			}
+			s.mu.Unlock()
+			return value, false, false, err
+		}
+	}
+	// 3. cache is valid
+	return value, true, true, err
+}
+
+// push writes entry at the tail of the ring buffer, evicting the oldest entries if needed.
+func (s *arenaShard) push(hash uint64, entry []byte) bool {
+	n := len(entry)
+	capacity := len(s.buf)
+	if n > capacity {
+		return false
+	}
+	for {
+		if s.used == 0 {
+			s.head, s.tail = 0, 0
+		}
+		wrapped := s.tail < s.head || (s.tail == s.head && s.used > 0)
+		if !wrapped {
+			if capacity-s.tail >= n {
+				break
+			}
+			// not enough room at the end: pad and wrap to the beginning
+			if capacity-s.tail >= 4 {
+				binary.LittleEndian.PutUint32(s.buf[s.tail:], 0)
+			}
+			s.used += capacity - s.tail
+			s.tail = 0
+			continue
+		}
+		if s.head-s.tail >= n {
+			break
+		}
+		s.evictHead()
+	}
+	copy(s.buf[s.tail:], entry)
+	s.index[hash] = uint32(s.tail)
+	s.tail += n
+	s.used += n
+	return true
+}
+
+// evictHead drops the oldest entry (or the wrap padding) of the ring buffer.
+func (s *arenaShard) evictHead() {
+	capacity := len(s.buf)
+	if capacity-s.head < 4 || binary.LittleEndian.Uint32(s.buf[s.head:]) == 0 {
+		s.used -= capacity - s.head
+		s.head = 0
+		return
+	}
+	size := int(binary.LittleEndian.Uint32(s.buf[s.head:]))
+	hash := binary.LittleEndian.Uint64(s.buf[s.head+4:])
+	if offset, ok := s.index[hash]; ok && int(offset) == s.head {
+		delete(s.index, hash)
+	}
+	s.head += size
+	s.used -= size
+	if s.head == capacity {
+		s.head = 0
+	}
+}
+
+func (m *arenaCacheMap) Delete(key any) {
+	kb, err := m.keyBytes(key)
+	if err != nil {
+		return
+	}
+	hash := arenaHash(kb)
+	s := m.shard(hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.index, hash)
+}
+
+func (m *arenaCacheMap) Clear() {
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.index = map[uint64]uint32{}
+		s.head, s.tail, s.used = 0, 0, 0
+		s.mu.Unlock()
+	}
+}
+
+// Len returns the number of entries which are still indexed.
+func (m *arenaCacheMap) Len() int {
+	total := 0
+	for _, s := range m.shards {
+		s.mu.RLock()
+		total += len(s.index)
+		s.mu.RUnlock()
+	}
+	return total
+}
+
+func (m *arenaCacheMap) SetTTL(ttl time.Duration) CacheMap {
+	m.ttl = ttl
+	return m
+}
+
+func (m *arenaCacheMap) SetErrTTL(errTTL time.Duration) CacheMap {
+	m.errTtl = errTTL
+	return m
+}
+
+func (m *arenaCacheMap) SetReuseTTL(ttl time.Duration) CacheMap {
+	m.reuseTtl = ttl
+	return m
+}
+
+// SetCodec sets the codec of values(default: CodecMsgpack), its name is at most 255 bytes
+func (m *arenaCacheMap) SetCodec(codec Codec) CacheMap {
+	if codec != nil && len(codec.Name()) > 255 {
+		panic("gofnext: codec name is too long")
+	}
+	m.codec = codec
+	return m
+}
+
+// SetSchemaVersion sets the schema version written into the entry(at most 255 bytes)
+func (m *arenaCacheMap) SetSchemaVersion(version string) CacheMap {
+	if len(version) > 255 {
+		panic("gofnext: schema version is too long")
+	}
+	m.schema = version
+	return m
+}
+
+func (m *arenaCacheMap) SchemaVersion() string {
+	return m.schema
+}
+
+func (m *arenaCacheMap) NeedMarshal() bool {
+	return true
+}
@@ -0,0 +1,146 @@
+package gofnext
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/ahuigo/gofnext/serial"
+)
+
+func TestCacheArena_StoreAndLoad(t *testing.T) {
+	m := NewCacheArena(1 << 20)
+
+	// Store a value
+	v := "value1"
+	m.Store("key1", &v, nil)
+
+	// Load the value
+	value, hasCache, alive, err := m.Load("key1")
+	if !(hasCache && alive) {
+		t.Fatalf("Expected key1 to exist")
+	}
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	var got string
+	if err := value.(*encodedValue).decode(&got); err != nil || got != "value1" {
+		t.Errorf("Expected value1, got: %v(%v)", got, err)
+	}
+
+	// Load a non-existent key
+	if _, hasCache, _, _ = m.Load("key2"); hasCache {
+		t.Fatal("Expected key2 to not exist")
+	}
+
+	// Non-string key
+	m.Store([2]any{1, "a"}, &v, nil)
+	if _, hasCache, _, _ = m.Load([2]any{1, "a"}); !hasCache {
+		t.Fatal("Expected [1,a] to exist")
+	}
+	// string key and the dump of other key never share a slot
+	m.Store(`v3:int(1)`, &v, nil)
+	if _, hasCache, _, _ = m.Load(1); hasCache {
+		t.Fatal("Expected 1 to not exist")
+	}
+
+	// Store a value with an error
+	m.Store("key3", &v, errors.New("some error"))
+	m.SetErrTTL(-1)
+	_, _, _, err = m.Load("key3")
+	if err == nil || err.Error() != "some error" {
+		t.Fatalf("Expected 'some error', got: %v", err)
+	}
+}
+
+func TestCacheArena_SetTTL(t *testing.T) {
+	m := NewCacheArena(1 << 20)
+	m.SetTTL(10 * time.Millisecond)
+	m.SetReuseTTL(10 * time.Millisecond)
+	m.Store("key1", 1, nil)
+
+	_, hasCache, alive, _ := m.Load("key1")
+	if !(hasCache && alive) {
+		t.Errorf("Expected key1 to exist")
+	}
+
+	// reuse ttl
+	time.Sleep(time.Millisecond * 10)
+	_, hasCache, alive, _ = m.Load("key1")
+	if !(hasCache && !alive) {
+		t.Errorf("Unexpected cache: hasCache=%v, alive=%v", hasCache, alive)
+	}
+
+	// expired
+	time.Sleep(time.Millisecond * 10)
+	if _, hasCache, _, _ = m.Load("key1"); hasCache {
+		t.Errorf("there should be no cache")
+	}
+}
+
+func TestCacheArena_Evict(t *testing.T) {
+	m := NewCacheArena(0) // min shard size
+	for i := 0; i < 10000; i++ {
+		m.Store(fmt.Sprintf("key%d", i), i, nil)
+	}
+	if m.Len() >= 10000 {
+		t.Fatalf("Expected old entries to be evicted, got %d entries", m.Len())
+	}
+
+	// The latest entries are always kept
+	value, hasCache, _, _ := m.Load("key9999")
+	if !hasCache {
+		t.Fatal("Expected key9999 to exist")
+	}
+	var got int
+	if err := value.(*encodedValue).decode(&got); err != nil || got != 9999 {
+		t.Errorf("Expected 9999, got: %v(%v)", got, err)
+	}
+
+	// Entries larger than a shard are dropped
+	m.Store("big", make([]byte, arenaMinShardSize), nil)
+	if _, hasCache, _, _ = m.Load("big"); hasCache {
+		t.Errorf("Expected big entry to be dropped")
+	}
+}
+
+func TestCacheArena_LongCodec(t *testing.T) {
+	m := NewCacheArena(1 << 20)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("codec name longer than 255 bytes should be rejected")
+		}
+	}()
+	m.SetCodec(&namedCodec{Codec: CodecMsgpack, name: strings.Repeat("c", 256)})
+}
+
+type namedCodec struct {
+	Codec
+	name string
+}
+
+func (c *namedCodec) Name() string { return c.name }
+
+func TestCacheArena_KeyError(t *testing.T) {
+	// keys which can't be dumped are cache misses rather than panics
+	m := NewCacheArena(1 << 20)
+	var x int
+	key := unsafe.Pointer(&x)
+	m.Store(key, 1, nil)
+	if _, hasCache, _, err := m.Load(key); hasCache || !errors.Is(err, serial.ErrUnsupportedKind) {
+		t.Fatalf("unexpected load: %v(%v)", hasCache, err)
+	}
+	m.Delete(key)
+
+	executeCount := 0
+	getNum := CacheFn1(func(p unsafe.Pointer) int {
+		executeCount++
+		return 1
+	}, &Config{CacheMap: m})
+	getNum(key)
+	getNum(key)
+	AssertEqual(t, executeCount, 2)
+}
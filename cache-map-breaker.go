@@ -0,0 +1,313 @@
+package gofnext
+
+import (
+	"hash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BreakerState is the state of circuit breaker
+type BreakerState int32
+
+const (
+	// BreakerClosed: requests are sent to the remote CacheMap
+	BreakerClosed BreakerState = iota
+	// BreakerOpen: the remote CacheMap is skipped during cool-down
+	BreakerOpen
+	// BreakerHalfOpen: a probe request is sent to the remote CacheMap after cool-down
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerStats is the stats of circuit breaker
+type BreakerStats struct {
+	State    BreakerState
+	Failures int   // consecutive failures
+	Opens    int64 // times the breaker has been opened
+	Skipped  int64 // requests which skip the remote CacheMap
+}
+
+/*
+breakerCacheMap is a circuit breaker around remote CacheMap(e.g. redis):
+  - After `failures` consecutive backend errors, the remote is skipped for `coolDown`(the function is called directly,
+    or the fallback CacheMap is used).
+  - After cool-down, one probe request is sent to the remote(half-open): it closes the breaker on success, or reopens it on failure.
+
+The remote CacheMap should implement CacheMapChecked to report backend errors.
+*/
+type breakerCacheMap struct {
+	remote   CacheMap
+	fallback CacheMap
+	failures int
+	coolDown time.Duration
+
+	mu        sync.Mutex
+	state     BreakerState
+	failCount int
+	openedAt  time.Time
+	probing   bool
+	opens     atomic.Int64
+	skipped   atomic.Int64
+	ttl       time.Duration
+	errTtl    time.Duration
+	reuseTtl  time.Duration
+	keyDigest func() hash.Hash // see SetHashKeyDigest
+}
+
+func NewCacheBreaker(remote CacheMap, failures int, coolDown time.Duration) *breakerCacheMap {
+	if remote == nil {
+		panic("NewCacheBreaker: remote cannot be nil")
+	}
+	if failures <= 0 {
+		failures = 1
+	}
+	return &breakerCacheMap{
+		remote:   remote,
+		failures: failures,
+		coolDown: coolDown,
+	}
+}
+
+// SetFallback sets an in-memory CacheMap used when the remote is unavailable(e.g. NewCacheLru(1000))
+func (m *breakerCacheMap) SetFallback(fallback CacheMap) *breakerCacheMap {
+	m.fallback = fallback
+	m.fallback.SetTTL(m.ttl)
+	m.fallback.SetErrTTL(m.errTtl)
+	m.fallback.SetReuseTTL(m.reuseTtl)
+	return m
+}
+
+// Stats returns the stats of breaker
+func (m *breakerCacheMap) Stats() BreakerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.state
+	if state == BreakerOpen && time.Since(m.openedAt) >= m.coolDown {
+		state = BreakerHalfOpen
+	}
+	return BreakerStats{
+		State:    state,
+		Failures: m.failCount,
+		Opens:    m.opens.Load(),
+		Skipped:  m.skipped.Load(),
+	}
+}
+
+// allow reports whether the request can be sent to the remote(probe: it is the half-open probe request)
+func (m *breakerCacheMap) allow() (allowed, probe bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.state {
+	case BreakerClosed:
+		return true, false
+	case BreakerOpen:
+		if time.Since(m.openedAt) < m.coolDown {
+			break
+		}
+		m.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if !m.probing {
+			m.probing = true
+			return true, true
+		}
+	}
+	m.skipped.Add(1)
+	return false, false
+}
+
+// done records the result of the request sent to the remote
+func (m *breakerCacheMap) done(probe bool, backendErr error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if probe {
+		m.probing = false
+	}
+	if backendErr == nil {
+		if probe || m.state == BreakerClosed {
+			m.state = BreakerClosed
+			m.failCount = 0
+		}
+		return
+	}
+	m.failCount++
+	if probe || (m.state == BreakerClosed && m.failCount >= m.failures) {
+		m.state = BreakerOpen
+		m.openedAt = time.Now()
+		m.opens.Add(1)
+	}
+}
+
+func (m *breakerCacheMap) HashKeyFunc(key ...any) []byte {
+	if remote, ok := m.remote.(interface{ HashKeyFunc(...any) []byte }); ok {
+		return remote.HashKeyFunc(key...)
+	}
+	return dumpHashKey(key...)
+}
+
+// BackendKey returns the backend key of remote(empty if remote has no backend key)
+func (m *breakerCacheMap) BackendKey(key any) string {
+	if remote, ok := m.remote.(CacheMapBackendKey); ok {
+		return remote.BackendKey(key)
+	}
+	return ""
+}
+
+func (m *breakerCacheMap) Store(key, value any, err error) {
+	if m.fallback != nil {
+		m.fallback.Store(key, value, err)
+	}
+	allowed, probe := m.allow()
+	if !allowed {
+		return
+	}
+	if remote, ok := m.remote.(CacheMapChecked); ok {
+		m.done(probe, remote.StoreChecked(key, value, err))
+	} else {
+		m.remote.Store(key, value, err)
+		m.done(probe, nil)
+	}
+}
+
+func (m *breakerCacheMap) Load(key any) (value any, hasCache, alive bool, err error) {
+	allowed, probe := m.allow()
+	if allowed {
+		var backendErr error
+		if remote, ok := m.remote.(CacheMapChecked); ok {
+			value, hasCache, alive, err, backendErr = remote.LoadChecked(key)
+		} else {
+			value, hasCache, alive, err = m.remote.Load(key)
+		}
+		m.done(probe, backendErr)
+		if backendErr == nil {
+			return value, hasCache, alive, err
+		}
+	}
+	// the remote is unavailable
+	if m.fallback != nil {
+		return m.fallback.Load(key)
+	}
+	return nil, false, false, nil
+}
+
+// LoadMany loads keys from remote in one round trip, or from fallback if the remote is unavailable(see CacheMapMultiLoader)
+func (m *breakerCacheMap) LoadMany(args []any) ([]CacheLoadResult, error) {
+	return loadMany(m, m.keyDigest, args)
+}
+
+func (m *breakerCacheMap) loadKeys(keys []any) ([]CacheLoadResult, error) {
+	allowed, probe := m.allow()
+	if allowed {
+		results, backendErr := loadKeysOf(m.remote, keys)
+		m.done(probe, backendErr)
+		if backendErr == nil || m.fallback == nil {
+			return results, backendErr
+		}
+	}
+	// the remote is unavailable
+	if m.fallback != nil {
+		return loadKeysOf(m.fallback, keys)
+	}
+	return make([]CacheLoadResult, len(keys)), nil
+}
+
+// Lock acquires the lock of remote only if the breaker is closed
+func (m *breakerCacheMap) Lock(key any) (unlock func(), acquired bool) {
+	if locker, ok := m.remote.(CacheMapLocker); ok && m.Stats().State == BreakerClosed {
+		return locker.Lock(key)
+	}
+	return func() {}, true
+}
+
+func (m *breakerCacheMap) Delete(key any) {
+	if fallback, ok := m.fallback.(CacheMapDeleter); ok {
+		fallback.Delete(key)
+	}
+	if remote, ok := m.remote.(CacheMapDeleter); ok {
+		remote.Delete(key)
+	}
+}
+
+func (m *breakerCacheMap) Clear() {
+	if fallback, ok := m.fallback.(CacheMapDeleter); ok {
+		fallback.Clear()
+	}
+	if remote, ok := m.remote.(CacheMapDeleter); ok {
+		remote.Clear()
+	}
+}
+
+func (m *breakerCacheMap) SetCodec(codec Codec) CacheMap {
+	if remote, ok := m.remote.(CacheMapCodec); ok {
+		remote.SetCodec(codec)
+	}
+	return m
+}
+
+func (m *breakerCacheMap) SetSchemaVersion(version string) CacheMap {
+	if remote, ok := m.remote.(CacheMapSchema); ok {
+		remote.SetSchemaVersion(version)
+	}
+	return m
+}
+
+// SetHashKeyDigest sets the digest of keys derived by LoadMany(see Config.HashKeyDigest)
+func (m *breakerCacheMap) SetHashKeyDigest(digest func() hash.Hash) CacheMap {
+	m.keyDigest = digest
+	return m
+}
+
+func (m *breakerCacheMap) SchemaVersion() string {
+	if remote, ok := m.remote.(cacheMapSchemaVersion); ok {
+		return remote.SchemaVersion()
+	}
+	return ""
+}
+
+func (m *breakerCacheMap) SetTTL(ttl time.Duration) CacheMap {
+	m.ttl = ttl
+	m.remote.SetTTL(ttl)
+	if m.fallback != nil {
+		m.fallback.SetTTL(ttl)
+	}
+	return m
+}
+
+func (m *breakerCacheMap) SetErrTTL(errTTL time.Duration) CacheMap {
+	m.errTtl = errTTL
+	m.remote.SetErrTTL(errTTL)
+	if m.fallback != nil {
+		m.fallback.SetErrTTL(errTTL)
+	}
+	return m
+}
+
+func (m *breakerCacheMap) SetReuseTTL(ttl time.Duration) CacheMap {
+	m.reuseTtl = ttl
+	m.remote.SetReuseTTL(ttl)
+	if m.fallback != nil {
+		m.fallback.SetReuseTTL(ttl)
+	}
+	return m
+}
+
+func (m *breakerCacheMap) NeedMarshal() bool {
+	return m.remote.NeedMarshal()
+}
+
+// Healthy reports whether the remote is available(see NewCacheShardedRemote)
+func (m *breakerCacheMap) Healthy() bool {
+	return m.Stats().State != BreakerOpen
+}
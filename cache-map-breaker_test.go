@@ -0,0 +1,90 @@
+package gofnext
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyRedisClient fails all hash commands when it is down
+type flakyRedisClient struct {
+	*fakeRedisClient
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+func (c *flakyRedisClient) HGet(key, field string) ([]byte, error) {
+	c.calls.Add(1)
+	if c.down.Load() {
+		return nil, errors.New("i/o timeout")
+	}
+	return c.fakeRedisClient.HGet(key, field)
+}
+
+func (c *flakyRedisClient) HSet(key, field string, value []byte) error {
+	c.calls.Add(1)
+	if c.down.Load() {
+		return errors.New("i/o timeout")
+	}
+	return c.fakeRedisClient.HSet(key, field, value)
+}
+
+func TestCacheBreaker(t *testing.T) {
+	client := &flakyRedisClient{fakeRedisClient: newFakeRedisClient()}
+	cacheMap := NewCacheBreaker(NewCacheRedis("breaker").SetRedisClient(client), 2, 50*time.Millisecond)
+	executeCount := 0
+	getNum := CacheFn1(func(i int) int {
+		executeCount++
+		return i * 2
+	}, &Config{CacheMap: cacheMap})
+
+	// 1. redis is down: open after 2 failures(load+store)
+	client.down.Store(true)
+	AssertEqual(t, getNum(1), 2)
+	AssertEqual(t, cacheMap.Stats().State, BreakerOpen)
+
+	// 2. skip redis during cool-down(call the function directly)
+	calls := client.calls.Load()
+	AssertEqual(t, getNum(1), 2)
+	AssertEqual(t, client.calls.Load(), calls)
+	AssertEqual(t, executeCount, 2)
+	if cacheMap.Stats().Skipped == 0 {
+		t.Fatal("requests should be skipped")
+	}
+
+	// 3. half-open probe fails: reopen
+	time.Sleep(60 * time.Millisecond)
+	AssertEqual(t, cacheMap.Stats().State, BreakerHalfOpen)
+	getNum(1)
+	AssertEqual(t, cacheMap.Stats().State, BreakerOpen)
+	AssertEqual(t, cacheMap.Stats().Opens, int64(2))
+
+	// 4. redis recovers: probe succeeds and closes the breaker
+	client.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	getNum(1)
+	AssertEqual(t, cacheMap.Stats().State, BreakerClosed)
+	getNum(1)
+	stats := cacheMap.Stats()
+	AssertEqual(t, stats.State, BreakerClosed)
+	AssertEqual(t, stats.Failures, 0)
+}
+
+func TestCacheBreaker_Fallback(t *testing.T) {
+	client := &flakyRedisClient{fakeRedisClient: newFakeRedisClient()}
+	client.down.Store(true)
+	cacheMap := NewCacheBreaker(NewCacheRedis("breaker-fallback").SetRedisClient(client), 1, time.Hour).
+		SetFallback(newCacheMapMem(0))
+	executeCount := 0
+	getNum := CacheFn1(func(i int) int {
+		executeCount++
+		return i * 2
+	}, &Config{CacheMap: cacheMap})
+	for i := 0; i < 3; i++ {
+		AssertEqual(t, getNum(1), 2)
+	}
+	// the in-memory fallback serves the cache while redis is down
+	AssertEqual(t, executeCount, 1)
+	AssertEqual(t, cacheMap.Stats().State, BreakerOpen)
+}
@@ -79,6 +79,21 @@ func (m *cacheLru) Load(key any) (value any, hasCache, alive bool, err error) {
 	return
 }
 
+func (m *cacheLru) Delete(key any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elInter, ok := m.listMap.LoadAndDelete(key); ok {
+		m.list.Remove(elInter.(*cachedNode).element)
+	}
+}
+
+func (m *cacheLru) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.list.Init()
+	m.listMap = &sync.Map{}
+}
+
 func (m *cacheLru) SetTTL(ttl time.Duration) CacheMap {
 	m.ttl = ttl
 	return m
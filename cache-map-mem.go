@@ -57,6 +57,17 @@ func (m *memCacheMap) Load(key any) (value any, hasCache bool, alive bool, err e
 	return
 }
 
+func (m *memCacheMap) Delete(key any) {
+	m.Map.Delete(key)
+}
+
+func (m *memCacheMap) Clear() {
+	m.Map.Range(func(key, _ any) bool {
+		m.Map.Delete(key)
+		return true
+	})
+}
+
 func (m *memCacheMap) SetTTL(ttl time.Duration) CacheMap {
 	m.ttl = ttl
 	return m
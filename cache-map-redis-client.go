@@ -0,0 +1,156 @@
+package gofnext
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrRedisNil should be returned by RedisClient when the key(or field) does not exist.
+var ErrRedisNil = errors.New("gofnext: redis nil")
+
+// ErrRedisEvalUnsupported is returned by RedisClient.Eval if scripts are not supported(the distributed lock is disabled)
+var ErrRedisEvalUnsupported = errors.New("gofnext: redis eval is not supported")
+
+/*
+RedisClient is the minimal redis client used by redisMap.
+You can adapt your own client library(or wrapper with tracing, auth rotation...) to it,
+or inject an in-process fake client in tests.
+
+Eval should return ErrRedisEvalUnsupported if the client can't run scripts, then the distributed lock is disabled quietly.
+
+Optionally, a client can implement `ForEachNode(fn func(RedisClient) error) error` to run
+SCAN on every master node(e.g. redis cluster).
+*/
+type RedisClient interface {
+	HGet(key, field string) ([]byte, error)
+	HSet(key, field string, value []byte) error
+	HDel(key string, fields ...string) error
+	Del(keys ...string) error
+	Get(key string) ([]byte, error)
+	// Set value with expiration(0: no expiration)
+	Set(key string, value []byte, expiration time.Duration) error
+	Eval(script string, keys []string, args ...any) (any, error)
+	Scan(cursor uint64, match string, count int64) (keys []string, next uint64, err error)
+}
+
+// RedisMultiGetter is optionally implemented by RedisClient to load many entries in one round trip(see redisMap.LoadMany).
+// nil is returned for missing entries.
+type RedisMultiGetter interface {
+	HMGet(key string, fields ...string) ([][]byte, error)
+	// GetMany gets keys by pipeline(keys may belong to different nodes of redis cluster)
+	GetMany(keys ...string) ([][]byte, error)
+}
+
+// RedisMultiDeleter is optionally implemented by RedisClient to delete many keys by pipeline.
+// Each key is deleted by its own command, since a multi-key DEL fails with CROSSSLOT on redis cluster.
+type RedisMultiDeleter interface {
+	DelMany(keys ...string) error
+}
+
+// goRedisClient adapts github.com/go-redis/redis to RedisClient
+type goRedisClient struct {
+	client redis.UniversalClient
+}
+
+func NewGoRedisClient(client redis.UniversalClient) RedisClient {
+	return &goRedisClient{client: client}
+}
+
+func goRedisErr(err error) error {
+	if err == redis.Nil {
+		return ErrRedisNil
+	}
+	return err
+}
+
+func (c *goRedisClient) HGet(key, field string) ([]byte, error) {
+	val, err := c.client.HGet(key, field).Bytes()
+	return val, goRedisErr(err)
+}
+
+func (c *goRedisClient) HSet(key, field string, value []byte) error {
+	return c.client.HSet(key, field, value).Err()
+}
+
+func (c *goRedisClient) HDel(key string, fields ...string) error {
+	return c.client.HDel(key, fields...).Err()
+}
+
+func (c *goRedisClient) Del(keys ...string) error {
+	return c.client.Del(keys...).Err()
+}
+
+func (c *goRedisClient) Get(key string) ([]byte, error) {
+	val, err := c.client.Get(key).Bytes()
+	return val, goRedisErr(err)
+}
+
+func (c *goRedisClient) Set(key string, value []byte, expiration time.Duration) error {
+	return c.client.Set(key, value, expiration).Err()
+}
+
+func (c *goRedisClient) Eval(script string, keys []string, args ...any) (any, error) {
+	val, err := c.client.Eval(script, keys, args...).Result()
+	return val, goRedisErr(err)
+}
+
+func (c *goRedisClient) HMGet(key string, fields ...string) ([][]byte, error) {
+	vals, err := c.client.HMGet(key, fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]byte, len(vals))
+	for i, val := range vals {
+		if s, ok := val.(string); ok {
+			res[i] = []byte(s)
+		}
+	}
+	return res, nil
+}
+
+func (c *goRedisClient) GetMany(keys ...string) ([][]byte, error) {
+	pipe := c.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(key)
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	res := make([][]byte, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.Bytes()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		res[i] = val
+	}
+	return res, nil
+}
+
+func (c *goRedisClient) DelMany(keys ...string) error {
+	pipe := c.client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(key)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+func (c *goRedisClient) Scan(cursor uint64, match string, count int64) ([]string, uint64, error) {
+	return c.client.Scan(cursor, match, count).Result()
+}
+
+// ForEachNode runs fn on every master node of redis cluster(or the client itself)
+func (c *goRedisClient) ForEachNode(fn func(RedisClient) error) error {
+	if clusterClient, ok := c.client.(*redis.ClusterClient); ok {
+		return clusterClient.ForEachMaster(func(client *redis.Client) error {
+			return fn(&goRedisClient{client: client})
+		})
+	}
+	return fn(c)
+}
@@ -0,0 +1,173 @@
+package gofnext
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Distributed lock(lease) of redisMap:
+  - acquire: SET lock NX PX lease, the lock's value is a fencing token(INCR fence)
+  - store: the holder compares its token and writes in one lua script, so a holder whose lease expired won't overwrite newer value
+  - release: compare-and-delete by lua script
+  - waiters on other instances poll the cache until the holder stores the fresh value.
+    If the holder dies, its lease expires and one of the waiters acquires it.
+*/
+
+const leaseAcquireScript = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+local token = redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[2])
+redis.call('SET', KEYS[1], token, 'PX', ARGV[1])
+return token`
+
+// leaseStoreScript writes the entry if the lease is held: ARGV = token, value, ttl(ms), [field of hash]
+const leaseStoreScript = `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if #ARGV == 4 then
+	redis.call('HSET', KEYS[2], ARGV[4], ARGV[2])
+elseif tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[2], ARGV[2])
+end
+return 1`
+
+const leaseReleaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+/*
+SetDistributedLock enables cross-process lock with leaseTTL(0: disable).
+If an expired key is requested by many processes at the same time, only the lock holder executes the function.
+leaseTTL should be longer than the function's execution time.
+*/
+func (m *redisMap) SetDistributedLock(leaseTTL time.Duration) *redisMap {
+	m.leaseTtl = leaseTTL
+	return m
+}
+
+/*
+leaseKeys returns the lock key and fence key of pkey, they are tagged with the hash slot of the entry(see slotTag),
+so that leaseStoreScript can access the lock and the entry on redis cluster.
+colocated is false if the slot can't be expressed by a hash tag(the tag would contain '}').
+*/
+func (m *redisMap) leaseKeys(pkey string) (lockKey, fenceKey string, colocated bool) {
+	tag := slotTag(m.redisFuncKey)
+	if m.keyPerEntry {
+		tag = slotTag(m.entryKey(pkey))
+	}
+	prefix := "{" + tag + "}:" + m.entryKey(pkey)
+	return prefix + ":lock", prefix + ":fence", !strings.Contains(tag, "}")
+}
+
+// slotTag returns the part of key hashed by redis cluster: the content of the first non-empty {tag}, or the whole key
+func slotTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
+
+func (m *redisMap) Lock(key any) (unlock func(), acquired bool) {
+	noop := func() {}
+	if m.leaseTtl <= 0 || m.noEval.Load() {
+		return noop, true
+	}
+	pkey := m.strkey(key)
+	lockKey, fenceKey, _ := m.leaseKeys(pkey)
+	pollInterval := m.leaseTtl / 10
+	if pollInterval < 10*time.Millisecond {
+		pollInterval = 10 * time.Millisecond
+	}
+	// give up waiting if the lock can not be acquired(e.g. lock holders keep dying)
+	deadline := time.Now().Add(3 * m.leaseTtl)
+	for {
+		res, err := m.redisClient.Eval(leaseAcquireScript, []string{lockKey, fenceKey},
+			m.leaseTtl.Milliseconds(), (10 * m.leaseTtl).Milliseconds())
+		if errors.Is(err, ErrRedisEvalUnsupported) {
+			// fallback quietly: the client never supports the lock
+			if m.noEval.CompareAndSwap(false, true) {
+				slogger.Warn("gofnext.redisMap: distributed lock is disabled", "err", err.Error())
+			}
+			return noop, true
+		} else if err != nil {
+			// fallback: execute the function without lock
+			slogger.Error("gofnext.redisMap: lock", "err", err.Error())
+			return noop, true
+		}
+		if token := toInt64(res); token > 0 {
+			tokenStr := strconv.FormatInt(token, 10)
+			m.leases.Store(pkey, tokenStr)
+			return func() {
+				m.leases.Delete(pkey)
+				if _, err := m.redisClient.Eval(leaseReleaseScript, []string{lockKey}, tokenStr); err != nil {
+					slogger.Error("gofnext.redisMap: unlock", "err", err.Error())
+				}
+			}, true
+		}
+		if time.Now().After(deadline) {
+			return noop, true
+		}
+
+		// wait for the lock holder to store the fresh value
+		sleepRandom(pollInterval/2, pollInterval)
+		if _, hasCache, alive, _ := m.Load(key); hasCache && alive {
+			return noop, false
+		}
+	}
+}
+
+/*
+setEntryFenced writes the entry if the lease of pkey(acquired by this instance) is still valid,
+stored is false if the lease has expired(another instance may have stored newer value).
+*/
+func (m *redisMap) setEntryFenced(pkey string, buf []byte, ttl time.Duration) (stored bool, err error) {
+	token, ok := m.leases.Load(pkey)
+	if !ok {
+		return true, m.setEntry(pkey, buf, ttl)
+	}
+	lockKey, _, colocated := m.leaseKeys(pkey)
+	if !colocated {
+		// check the lease before writing
+		if val, err := m.redisClient.Get(lockKey); err == nil || err == ErrRedisNil {
+			if string(val) != token.(string) {
+				return false, nil
+			}
+		}
+		return true, m.setEntry(pkey, buf, ttl)
+	}
+	var res any
+	if m.keyPerEntry {
+		res, err = m.redisClient.Eval(leaseStoreScript, []string{lockKey, m.entryKey(pkey)}, token, buf, ttl.Milliseconds())
+	} else {
+		res, err = m.redisClient.Eval(leaseStoreScript, []string{lockKey, m.redisFuncKey}, token, buf, ttl.Milliseconds(), pkey)
+	}
+	return toInt64(res) == 1, err
+}
+
+func toInt64(v any) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	case []byte:
+		n, _ := strconv.ParseInt(string(v), 10, 64)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package gofnext
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheRedis_DistributedLock(t *testing.T) {
+	stub := newRedisStub(t)
+	var executeCount atomic.Int32
+	getNum := func(i int) int {
+		executeCount.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		return i * 2
+	}
+
+	// two instances share the same redis
+	var getNumFns []func(int) int
+	for i := 0; i < 2; i++ {
+		cacheMap := NewCacheRedis("distributed-lock").SetRedisAddr(stub.Addr()).SetDistributedLock(time.Second)
+		getNumFns = append(getNumFns, CacheFn1(getNum, &Config{CacheMap: cacheMap}))
+	}
+	parallelCall(func() {
+		for _, getNumWithCache := range getNumFns {
+			AssertEqual(t, getNumWithCache(1), 2)
+		}
+	}, 10)
+	AssertEqual(t, executeCount.Load(), int32(1))
+}
+
+func TestCacheRedis_DistributedLockHolderDies(t *testing.T) {
+	for _, keyPerEntry := range []bool{false, true} {
+		testDistributedLockHolderDies(t, keyPerEntry)
+	}
+}
+
+func testDistributedLockHolderDies(t *testing.T, keyPerEntry bool) {
+	// the lock and the entry belong to the same slot of redis cluster
+	stub := newRedisStub(t)
+	stub.cluster = true
+	cacheMapA := NewCacheRedis("lock-holder-dies").SetRedisAddr(stub.Addr()).SetKeyPerEntry(keyPerEntry).SetDistributedLock(100 * time.Millisecond)
+	cacheMapB := NewCacheRedis("lock-holder-dies").SetRedisAddr(stub.Addr()).SetKeyPerEntry(keyPerEntry).SetDistributedLock(100 * time.Millisecond)
+
+	// instance A acquires the lease and dies(never unlock)
+	_, acquired := cacheMapA.Lock(hashKey(1))
+	if !acquired {
+		t.Fatal("lock should be acquired")
+	}
+
+	// instance B waits until the lease expires
+	start := time.Now()
+	getNum := CacheFn1(func(i int) int { return i * 2 }, &Config{CacheMap: cacheMapB})
+	AssertEqual(t, getNum(1), 2)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("unexpected elapsed time: %v", elapsed)
+	}
+
+	// instance A's lease has expired: it won't overwrite B's value(fencing token)
+	v := 100
+	cacheMapA.Store(hashKey(1), &v, nil)
+	value, hasCache, _, _ := cacheMapB.Load(hashKey(1))
+	var num int
+	if err := value.(*encodedValue).decode(&num); !hasCache || err != nil {
+		t.Fatalf("cache should exist: %v", err)
+	}
+	AssertEqual(t, num, 2)
+}
+
+func TestCacheRedis_DistributedLockTiered(t *testing.T) {
+	// the lock of redis(L2) is used behind tiered cache
+	stub := newRedisStub(t)
+	var executeCount atomic.Int32
+	getNum := func(i int) int {
+		executeCount.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		return i * 2
+	}
+	var getNumFns []func(int) int
+	for i := 0; i < 2; i++ {
+		l2 := NewCacheRedis("distributed-lock-tiered").SetRedisAddr(stub.Addr()).SetDistributedLock(time.Second)
+		getNumFns = append(getNumFns, CacheFn1(getNum, &Config{CacheMap: NewCacheTiered(NewCacheLru(100), l2)}))
+	}
+	// the replicas are called at the same time
+	var wg sync.WaitGroup
+	for _, getNumWithCache := range getNumFns {
+		wg.Add(1)
+		go func(getNumWithCache func(int) int) {
+			defer wg.Done()
+			AssertEqual(t, getNumWithCache(1), 2)
+		}(getNumWithCache)
+	}
+	wg.Wait()
+	AssertEqual(t, executeCount.Load(), int32(1))
+}
+
+func TestCacheRedis_DistributedLockFallback(t *testing.T) {
+	// The fake client does not support eval: execute the function without lock
+	cacheMap := NewCacheRedis("lock-fallback").SetRedisClient(newFakeRedisClient()).SetDistributedLock(time.Second)
+	var logs bytes.Buffer
+	defer func(logger *slog.Logger) { slogger = logger }(slogger)
+	slogger = slog.New(slog.NewTextHandler(&logs, nil))
+	for i := 0; i < 3; i++ {
+		unlock, acquired := cacheMap.Lock(strconv.Itoa(i))
+		if !acquired {
+			t.Fatal("should fallback to execute the function")
+		}
+		unlock()
+	}
+	// it is logged once rather than on every miss
+	AssertEqual(t, strings.Count(logs.String(), "distributed lock is disabled"), 1)
+	AssertEqual(t, strings.Count(logs.String(), "level=ERROR"), 0)
+}
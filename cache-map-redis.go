@@ -1,10 +1,12 @@
 package gofnext
 
 import (
-	"errors"
+	"hash"
 	"hash/fnv"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"crypto/md5"
@@ -16,19 +18,29 @@ import (
 )
 
 type redisMap struct {
-	mu            sync.Mutex
-	redisClient   redis.UniversalClient
+	redisClient   RedisClient
 	ttl           time.Duration
 	errTtl        time.Duration
 	reuseTtl      time.Duration
 	redisFuncKey  string
+	entryPrefix   string // prefix of entry keys in key-per-entry mode(see entryKey)
 	maxHashKeyLen int
+	keyPerEntry   bool
+	leaseTtl      time.Duration
+	codec         Codec
+	schema        string
+	keyDigest     func() hash.Hash // see SetHashKeyDigest
+	leases        sync.Map         // pkey -> fencing token of the lease held by this instance
+	noEval        atomic.Bool      // the client returns ErrRedisEvalUnsupported(see Lock)
 }
 
 type redisData struct {
-	Data      []byte
-	Err       []byte
-	CreatedAt time.Time
+	Data        []byte
+	Err         []byte
+	CreatedAt   time.Time
+	Codec       string `msgpack:",omitempty"` // content-type tag of Data(empty: msgpack)
+	Schema      string `msgpack:",omitempty"` // schema version of Data(see Config.SchemaVersion)
+	Fingerprint []byte `msgpack:",omitempty"` // fingerprint of the full key(see Config.KeyFingerprint)
 	// TTL       time.Duration
 }
 
@@ -43,41 +55,56 @@ func NewCacheRedis(funcKey string) *redisMap {
 	}
 	redisClient := redis.NewUniversalClient(config)
 	return &redisMap{
-		redisClient:  redisClient,
+		redisClient:  NewGoRedisClient(redisClient),
 		redisFuncKey: "_gofnext:" + funcKey,
+		entryPrefix:  "_gofnext-entry:" + strconv.Itoa(len(funcKey)) + ":" + funcKey + ":",
 	}
 }
 
 func (m *redisMap) SetRedisAddr(addr string) *redisMap {
-	m.redisClient = redis.NewClient(&redis.Options{
+	m.redisClient = NewGoRedisClient(redis.NewClient(&redis.Options{
 		Addr: addr,
-	})
+	}))
 	return m
 }
 
 func (m *redisMap) SetRedisOpts(opts *redis.Options) *redisMap {
-	m.redisClient = redis.NewClient(opts)
+	m.redisClient = NewGoRedisClient(redis.NewClient(opts))
 	return m
 }
 
 func (m *redisMap) SetRedisUniversalOpts(opts *redis.UniversalOptions) *redisMap {
-	m.redisClient = redis.NewUniversalClient(opts)
+	m.redisClient = NewGoRedisClient(redis.NewUniversalClient(opts))
+	return m
+}
+
+// SetRedisClient sets your own redis client(e.g. NewGoRedisClient(client), or a fake client in tests)
+func (m *redisMap) SetRedisClient(client RedisClient) *redisMap {
+	m.redisClient = client
 	return m
 }
 
 func (m *redisMap) ClearAll() *redisMap {
 	m.redisClient.Del(m.redisFuncKey)
+	m.clearEntryKeys()
 	return m
 }
 
+func (m *redisMap) Delete(key any) {
+	m.delEntry(m.strkey(key))
+}
+
+func (m *redisMap) Clear() {
+	m.ClearAll()
+}
+
+// RedisClient returns the redis client used by redisMap
+func (m *redisMap) RedisClient() RedisClient {
+	return m.redisClient
+}
+
 func (m *redisMap) HashKeyFunc(key ...any) []byte {
-	if len(key) == 0 {
-		return nil
-	} else if len(key) == 1 {
-		return serial.Bytes(key[0], false)
-	} else {
-		return serial.Bytes(key, false)
-	}
+	return dumpHashKey(key...)
 }
 
 func (m *redisMap) strkey(key any) string {
@@ -107,8 +134,21 @@ func (m *redisMap) strkey(key any) string {
 	return r
 }
 
+// BackendKey returns the redis key in key-per-entry mode, otherwise the field of the redis hash
+func (m *redisMap) BackendKey(key any) string {
+	if m.keyPerEntry {
+		return m.entryKey(m.strkey(key))
+	}
+	return m.strkey(key)
+}
+
 func (m *redisMap) Store(key, value any, err0 error) {
-	buf, err := marshalMsgpack(value)
+	_ = m.StoreChecked(key, value, err0)
+}
+
+// StoreChecked stores the value, and returns the error of redis(see NewCacheBreaker)
+func (m *redisMap) StoreChecked(key, value any, err0 error) (backendErr error) {
+	encoded, err := marshalValue(key, value, m.codec, m.schema)
 	if err != nil {
 		slogger.Error("gofnext.redisMap: marshal", "err", err.Error())
 		return
@@ -117,7 +157,10 @@ func (m *redisMap) Store(key, value any, err0 error) {
 	pkey := m.strkey(key)
 	// data, _ := json.Marshal(value)
 	cacheData := redisData{
-		Data: buf,
+		Data:        encoded.data,
+		Codec:       encoded.codec,
+		Schema:      encoded.schema,
+		Fingerprint: encoded.fingerprint,
 		// TTL:  m.ttl,
 	}
 	if err0 != nil && m.errTtl <= 0 {
@@ -128,34 +171,85 @@ func (m *redisMap) Store(key, value any, err0 error) {
 		cacheData.CreatedAt = time.Now()
 	}
 	if err0 != nil {
-		cacheData.Err = []byte(err0.Error())
+		cacheData.Err = marshalError(err0)
 	}
 
 	// encode value to bytes
-	if buf, err = marshalMsgpack(cacheData); err != nil {
+	buf, err := marshalMsgpack(cacheData)
+	if err != nil {
 		slogger.Error("gofnext.redisMap", "err", err.Error())
 		return
 	}
 	// buf, _ := json.Marshal(cacheData)
-	err = m.redisClient.HSet(m.redisFuncKey, pkey, buf).Err()
+	stored, err := m.setEntryFenced(pkey, buf, m.entryTTL(err0 != nil))
 	if err != nil {
 		slogger.Error("gofnext.redisMap", "err", err.Error())
+	} else if !stored {
+		// the lease has expired, and another instance may have stored newer value
+		slogger.Warn("gofnext.redisMap: lease expired, skip storing", "key", pkey)
 	}
+	return err
 }
 
 func (m *redisMap) Load(key any) (value any, hasCache, alive bool, err error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	value, hasCache, alive, err, _ = m.LoadChecked(key)
+	return
+}
+
+// LoadChecked loads the value, and returns the error of redis(see NewCacheBreaker)
+func (m *redisMap) LoadChecked(key any) (value any, hasCache, alive bool, err, backendErr error) {
 	pkey := m.strkey(key)
-	val, err := m.redisClient.HGet(m.redisFuncKey, pkey).Bytes()
+	val, err := m.getEntry(pkey)
 	// m.redisClient.TTL()
-	if err == redis.Nil {
+	if err == ErrRedisNil {
 		hasCache = false
 		err = nil
 		return
 	} else if err != nil {
+		backendErr = err
 		return
 	}
+	value, hasCache, alive, err = m.decodeEntry(key, pkey, val)
+	return
+}
+
+/*
+LoadMany loads the cache of many calls in one round trip(HMGET, or pipelined GET with key-per-entry layout),
+see CacheMapMultiLoader. The RedisClient should implement RedisMultiGetter, otherwise keys are loaded one by one.
+
+	results, err := cacheMap.LoadMany([]any{1, 2})
+	user, err := gofnext.DecodeLoadResult[*User](results[0])
+*/
+func (m *redisMap) LoadMany(args []any) ([]CacheLoadResult, error) {
+	return loadMany(m, m.keyDigest, args)
+}
+
+func (m *redisMap) loadKeys(keys []any) ([]CacheLoadResult, error) {
+	pkeys := make([]string, len(keys))
+	for i, key := range keys {
+		pkeys[i] = m.strkey(key)
+	}
+	results := make([]CacheLoadResult, len(keys))
+	vals, err := m.getEntries(pkeys)
+	if err != nil {
+		return results, err
+	}
+	for i, val := range vals {
+		if val == nil {
+			continue
+		}
+		r := &results[i]
+		r.Value, r.HasCache, r.Alive, r.Err = m.decodeEntry(keys[i], pkeys[i], val)
+		if encoded, ok := r.Value.(*encodedValue); ok && encoded.schema != m.schema {
+			// stored with another schema version
+			*r = CacheLoadResult{}
+		}
+	}
+	return results, nil
+}
+
+// decodeEntry decodes the envelope of entry stored by key(pkey is its redis key)
+func (m *redisMap) decodeEntry(key any, pkey string, val []byte) (value any, hasCache, alive bool, err error) {
 	cacheData := redisData{}
 	err = unmarshalMsgpack(val, &cacheData)
 	if err != nil {
@@ -163,23 +257,30 @@ func (m *redisMap) Load(key any) (value any, hasCache, alive bool, err error) {
 		return
 	}
 
-	value = cacheData.Data
+	value = &encodedValue{
+		data:        cacheData.Data,
+		codec:       cacheData.Codec,
+		schema:      cacheData.Schema,
+		fingerprint: cacheData.Fingerprint,
+		local:       m.codec,
+		key:         codecKey(key),
+	}
 	if cacheData.Err != nil {
-		err = errors.New(string(cacheData.Err))
+		err = unmarshalError(cacheData.Err)
 	}
 	if (m.ttl > 0 && time.Since(cacheData.CreatedAt) > m.ttl) ||
 		(m.errTtl >= 0 && cacheData.Err != nil && time.Since(cacheData.CreatedAt) > m.errTtl) {
 		// 1. cache is within reuse ttl
 		if m.reuseTtl > 0 && time.Since(cacheData.CreatedAt) < m.reuseTtl+m.ttl {
-			return value, true, false, nil
+			return value, true, false, err
 		} else {
 			// 2. cache is not valid
-			m.redisClient.HDel(m.redisFuncKey, pkey)
-			return value, false, false, nil
+			m.delEntry(pkey)
+			return value, false, false, err
 		}
 	} else {
 		// 3. cache is valid
-		return value, true, true, nil
+		return value, true, true, err
 	}
 }
 
@@ -196,11 +297,169 @@ func (m *redisMap) SetReuseTTL(ttl time.Duration) CacheMap {
 	return m
 }
 
+// SetCodec sets the codec of values(default: CodecMsgpack)
+func (m *redisMap) SetCodec(codec Codec) CacheMap {
+	m.codec = codec
+	return m
+}
+
+// SetSchemaVersion sets the schema version written into the envelope
+func (m *redisMap) SetSchemaVersion(version string) CacheMap {
+	m.schema = version
+	return m
+}
+
+func (m *redisMap) SchemaVersion() string {
+	return m.schema
+}
+
+// SetHashKeyDigest sets the digest of keys derived by LoadMany(see Config.HashKeyDigest)
+func (m *redisMap) SetHashKeyDigest(digest func() hash.Hash) CacheMap {
+	m.keyDigest = digest
+	return m
+}
+
 func (m *redisMap) SetMaxHashKeyLen(l int) *redisMap {
 	m.maxHashKeyLen = l
 	return m
 }
 
+/*
+SetKeyPerEntry stores each entry as its own redis key(`_gofnext-entry:<len(funcKey)>:<funcKey>:<key>`) instead of a field of one redis hash.
+So that redis can expire entries itself(TTL plus ReuseTTL), and entries are spread over slots in redis cluster.
+*/
+func (m *redisMap) SetKeyPerEntry(keyPerEntry bool) *redisMap {
+	m.keyPerEntry = keyPerEntry
+	return m
+}
+
+// entryKey is length-prefixed by funcKey, so that the keys(and the SCAN pattern) of funcKey "foo" never match those of "foo:bar"
+func (m *redisMap) entryKey(pkey string) string {
+	return m.entryPrefix + pkey
+}
+
+func (m *redisMap) getEntry(pkey string) ([]byte, error) {
+	if m.keyPerEntry {
+		return m.redisClient.Get(m.entryKey(pkey))
+	}
+	return m.redisClient.HGet(m.redisFuncKey, pkey)
+}
+
+// getEntries returns nil for missing entries
+func (m *redisMap) getEntries(pkeys []string) ([][]byte, error) {
+	if getter, ok := m.redisClient.(RedisMultiGetter); ok {
+		if !m.keyPerEntry {
+			return getter.HMGet(m.redisFuncKey, pkeys...)
+		}
+		entryKeys := make([]string, len(pkeys))
+		for i, pkey := range pkeys {
+			entryKeys[i] = m.entryKey(pkey)
+		}
+		return getter.GetMany(entryKeys...)
+	}
+	vals := make([][]byte, len(pkeys))
+	for i, pkey := range pkeys {
+		val, err := m.getEntry(pkey)
+		if err == ErrRedisNil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+func (m *redisMap) setEntry(pkey string, buf []byte, ttl time.Duration) error {
+	if m.keyPerEntry {
+		return m.redisClient.Set(m.entryKey(pkey), buf, ttl)
+	}
+	return m.redisClient.HSet(m.redisFuncKey, pkey, buf)
+}
+
+func (m *redisMap) delEntry(pkey string) {
+	if m.keyPerEntry {
+		m.redisClient.Del(m.entryKey(pkey))
+	} else {
+		m.redisClient.HDel(m.redisFuncKey, pkey)
+	}
+}
+
+// entryTTL returns the expiration of an entry key(0: no expiration), expired cache is kept for ReuseTTL
+func (m *redisMap) entryTTL(hasErr bool) time.Duration {
+	ttl := m.ttl
+	if hasErr && m.errTtl > 0 && (ttl == 0 || m.errTtl < ttl) {
+		ttl = m.errTtl
+	}
+	if ttl > 0 && m.reuseTtl > 0 && m.ttl+m.reuseTtl > ttl {
+		ttl = m.ttl + m.reuseTtl
+	}
+	return ttl
+}
+
+// clearEntryKeys deletes all entry keys with SCAN(on every master node of redis cluster)
+func (m *redisMap) clearEntryKeys() {
+	if !m.keyPerEntry {
+		return
+	}
+	pattern := escapeRedisPattern(m.entryKey("")) + "*"
+	scanDel := func(client RedisClient) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(cursor, pattern, 1000)
+			if err != nil {
+				return err
+			}
+			if err := delKeys(client, keys); err != nil {
+				return err
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+	var err error
+	if nodes, ok := m.redisClient.(interface {
+		ForEachNode(fn func(RedisClient) error) error
+	}); ok {
+		err = nodes.ForEachNode(scanDel)
+	} else {
+		err = scanDel(m.redisClient)
+	}
+	if err != nil {
+		slogger.Error("gofnext.redisMap: clear", "err", err.Error())
+	}
+}
+
+// delKeys deletes keys one by one(pipelined with RedisMultiDeleter), the keys may belong to different slots of redis cluster
+func delKeys(client RedisClient, keys []string) error {
+	if deleter, ok := client.(RedisMultiDeleter); ok {
+		if len(keys) == 0 {
+			return nil
+		}
+		return deleter.DelMany(keys...)
+	}
+	for _, key := range keys {
+		if err := client.Del(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeRedisPattern(s string) string {
+	var buf strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
 func (m *redisMap) NeedMarshal() bool {
 	return true
 }
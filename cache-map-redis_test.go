@@ -0,0 +1,343 @@
+package gofnext
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/ahuigo/gofnext/serial"
+)
+
+func TestCacheRedis_KeyPerEntry(t *testing.T) {
+	stub := newRedisStub(t)
+	cacheMap := NewCacheRedis("key-per-entry").SetRedisAddr(stub.Addr()).SetKeyPerEntry(true)
+	other := NewCacheRedis("key-per-entry*").SetRedisAddr(stub.Addr()).SetKeyPerEntry(true)
+
+	executeCount := 0
+	getNum := func(i int) (int, error) {
+		executeCount++
+		return i * 2, nil
+	}
+	getNumWithCache := CacheFn1Err(getNum, &Config{
+		TTL:      time.Minute,
+		ReuseTTL: time.Minute,
+		CacheMap: cacheMap,
+	})
+
+	for i := 0; i < 3; i++ {
+		num, err := getNumWithCache(1)
+		AssertEqual(t, num, 2)
+		AssertEqual(t, err, nil)
+		getNumWithCache(2)
+	}
+	AssertEqual(t, executeCount, 2)
+
+	// 1. each entry is a key with native expiry(TTL plus ReuseTTL)
+	pttl := stub.PTTL(cacheMap.entryKey(hashKey(1)))
+	if pttl < time.Minute || pttl > 2*time.Minute {
+		t.Fatalf("unexpected pttl: %v", pttl)
+	}
+	if len(stub.hashes) != 0 {
+		t.Fatal("redis hash should not be used")
+	}
+
+	// 2. ClearAll only deletes the function's own keys
+	other.Store(hashKey(1), 1, nil)
+	cacheMap.ClearAll()
+	AssertEqual(t, stub.PTTL(cacheMap.entryKey(hashKey(1))), -2)
+	AssertEqual(t, stub.PTTL(cacheMap.entryKey(hashKey(2))), -2)
+	AssertEqual(t, stub.PTTL(other.entryKey(hashKey(1))), -1)
+	getNumWithCache(1)
+	AssertEqual(t, executeCount, 3)
+}
+
+func TestCacheRedis_ClearPrefix(t *testing.T) {
+	// ClearAll of funcKey "foo" keeps the entries(and the hash) of funcKey "foo:bar"
+	stub := newRedisStub(t)
+	for _, keyPerEntry := range []bool{false, true} {
+		foo := NewCacheRedis("foo").SetRedisAddr(stub.Addr()).SetKeyPerEntry(true)
+		fooBar := NewCacheRedis("foo:bar").SetRedisAddr(stub.Addr()).SetKeyPerEntry(keyPerEntry)
+		executeCount := 0
+		getNum := CacheFn1(func(i int) int {
+			executeCount++
+			return i
+		}, &Config{CacheMap: fooBar})
+		getNum(1)
+		foo.Store(hashKey(1), 1, nil)
+		foo.ClearAll()
+		getNum(1)
+		AssertEqual(t, executeCount, 1)
+		fooBar.ClearAll()
+	}
+}
+
+func TestCacheRedis_ClearCluster(t *testing.T) {
+	// keys of entries belong to different slots: they are deleted one by one
+	stub := newRedisStub(t)
+	stub.cluster = true
+	AssertEqual(t, stubKeySlot("foo"), 12182)
+	cacheMap := NewCacheRedis("clear-cluster").SetRedisAddr(stub.Addr()).SetKeyPerEntry(true)
+
+	executeCount := 0
+	getNumWithCache := CacheFn1(func(i int) int {
+		executeCount++
+		return i * 2
+	}, &Config{CacheMap: cacheMap})
+	for i := 0; i < 10; i++ {
+		getNumWithCache(i)
+	}
+	cacheMap.ClearAll()
+	for i := 0; i < 10; i++ {
+		AssertEqual(t, stub.PTTL(cacheMap.entryKey(hashKey(i))), -2)
+	}
+	getNumWithCache(1)
+	AssertEqual(t, executeCount, 11)
+}
+
+func TestCacheRedis_EntryTTL(t *testing.T) {
+	m := NewCacheRedis("entry-ttl")
+	AssertEqual(t, m.entryTTL(false), 0)
+
+	m.SetTTL(time.Minute)
+	m.SetErrTTL(time.Second)
+	AssertEqual(t, m.entryTTL(false), time.Minute)
+	AssertEqual(t, m.entryTTL(true), time.Second)
+
+	m.SetReuseTTL(time.Minute)
+	AssertEqual(t, m.entryTTL(false), 2*time.Minute)
+	AssertEqual(t, m.entryTTL(true), 2*time.Minute)
+}
+
+// fakeRedisClient is an in-process RedisClient(without Eval)
+type fakeRedisClient struct {
+	mu     sync.Mutex
+	hashes map[string]map[string][]byte
+	strs   map[string][]byte
+}
+
+func newFakeRedisClient() *fakeRedisClient {
+	return &fakeRedisClient{
+		hashes: map[string]map[string][]byte{},
+		strs:   map[string][]byte{},
+	}
+}
+
+func (c *fakeRedisClient) HGet(key, field string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if val, ok := c.hashes[key][field]; ok {
+		return val, nil
+	}
+	return nil, ErrRedisNil
+}
+
+func (c *fakeRedisClient) HSet(key, field string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hashes[key] == nil {
+		c.hashes[key] = map[string][]byte{}
+	}
+	c.hashes[key][field] = value
+	return nil
+}
+
+func (c *fakeRedisClient) HDel(key string, fields ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, field := range fields {
+		delete(c.hashes[key], field)
+	}
+	return nil
+}
+
+func (c *fakeRedisClient) Del(keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.hashes, key)
+		delete(c.strs, key)
+	}
+	return nil
+}
+
+func (c *fakeRedisClient) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if val, ok := c.strs[key]; ok {
+		return val, nil
+	}
+	return nil, ErrRedisNil
+}
+
+func (c *fakeRedisClient) Set(key string, value []byte, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.strs[key] = value
+	return nil
+}
+
+func (c *fakeRedisClient) Eval(script string, keys []string, args ...any) (any, error) {
+	return nil, ErrRedisEvalUnsupported
+}
+
+func (c *fakeRedisClient) Scan(cursor uint64, match string, count int64) ([]string, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for key := range c.strs {
+		if stubMatch(match, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, 0, nil
+}
+
+func TestCacheRedis_CustomClient(t *testing.T) {
+	for _, keyPerEntry := range []bool{false, true} {
+		client := newFakeRedisClient()
+		cacheMap := NewCacheRedis("custom-client").SetRedisClient(client).SetKeyPerEntry(keyPerEntry)
+		executeCount := 0
+		getNum := func(i int) int {
+			executeCount++
+			return i * 2
+		}
+		getNumWithCache := CacheFn1(getNum, &Config{CacheMap: cacheMap})
+		AssertEqual(t, getNumWithCache(1), 2)
+		AssertEqual(t, getNumWithCache(1), 2)
+		AssertEqual(t, executeCount, 1)
+		if keyPerEntry {
+			AssertEqual(t, len(client.strs), 1)
+		} else {
+			AssertEqual(t, len(client.hashes["_gofnext:custom-client"]), 1)
+		}
+
+		cacheMap.ClearAll()
+		AssertEqual(t, len(client.strs)+len(client.hashes), 0)
+		AssertEqual(t, getNumWithCache(1), 2)
+		AssertEqual(t, executeCount, 2)
+	}
+}
+
+func TestCacheRedis_LoadMany(t *testing.T) {
+	stub := newRedisStub(t)
+	for _, keyPerEntry := range []bool{false, true} {
+		cacheMap := NewCacheRedis("load-many").SetRedisAddr(stub.Addr()).SetKeyPerEntry(keyPerEntry)
+		getNum := CacheFn1(func(i int) int { return i * 10 }, &Config{CacheMap: cacheMap})
+		for i := 0; i < 3; i++ {
+			getNum(i)
+		}
+		hget, get := stub.Calls("hget"), stub.Calls("get")
+		results, err := cacheMap.LoadMany([]any{0, 1, 2, 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertEqual(t, len(results), 4)
+		for i, r := range results[:3] {
+			v, err := DecodeLoadResult[int](r)
+			if !r.HasCache || !r.Alive || err != nil {
+				t.Fatalf("key %d should be loaded: %+v(%v)", i, r, err)
+			}
+			AssertEqual(t, v, i*10)
+		}
+		if results[3].HasCache {
+			t.Fatal("key 3 should not exist")
+		}
+		// one round trip instead of one HGET per key
+		if keyPerEntry {
+			AssertEqual(t, stub.Calls("get")-get, 4) // pipelined
+		} else {
+			AssertEqual(t, stub.Calls("hmget"), 1)
+			AssertEqual(t, stub.Calls("hget"), hget)
+		}
+	}
+
+	// clients without RedisMultiGetter load keys one by one
+	cacheMap := NewCacheRedis("load-many").SetRedisClient(newFakeRedisClient())
+	CacheFn1(func(s string) string { return s }, &Config{CacheMap: cacheMap})("a")
+	results, err := cacheMap.LoadMany([]any{"a", "b"})
+	if err != nil || !results[0].HasCache || results[1].HasCache {
+		t.Fatalf("unexpected results: %+v(%v)", results, err)
+	}
+	if v, err := DecodeLoadResult[string](results[0]); err != nil || v != "a" {
+		t.Fatalf("unexpected value: %q(%v)", v, err)
+	}
+
+	// errors of keys and redis are returned
+	if _, err := cacheMap.LoadMany([]any{unsafe.Pointer(nil)}); !errors.Is(err, serial.ErrUnsupportedKind) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := NewCacheRedis("load-many").SetRedisAddr("127.0.0.1:1").LoadMany([]any{1}); err == nil {
+		t.Fatal("error of redis should be returned")
+	}
+}
+
+func TestCacheRedis_ConcurrentLoad(t *testing.T) {
+	stub := newRedisStub(t)
+	cacheMap := NewCacheRedis("concurrent-load").SetRedisAddr(stub.Addr())
+	getNum := CacheFn1(func(i int) int { return i * 2 }, &Config{CacheMap: cacheMap})
+	parallelCall(func() {
+		for i := 0; i < 10; i++ {
+			AssertEqual(t, getNum(i), i*2)
+		}
+	}, 20)
+}
+
+// hashKey is the key of function arguments stored by redis-backed maps
+func hashKey(args ...any) string {
+	return string(dumpHashKey(args...))
+}
+
+func TestCacheRedis_AnyArgTypes(t *testing.T) {
+	// values of different types should not share a key
+	for _, cacheMap := range []CacheMap{NewCacheRedis("any-arg").SetRedisClient(newFakeRedisClient()), NewCacheArena(1 << 20)} {
+		executeCount := 0
+		typeOf := CacheFn1(func(v any) string {
+			executeCount++
+			return fmt.Sprintf("%T", v)
+		}, &Config{CacheMap: cacheMap})
+		for _, v := range []any{1, 1.0, int8(1), "1"} {
+			AssertEqual(t, typeOf(v), fmt.Sprintf("%T", v))
+		}
+		AssertEqual(t, executeCount, 4)
+	}
+}
+
+func TestCacheRedis_HashKeyDigest(t *testing.T) {
+	// keys derived by the redis map are digested as well
+	stub := newRedisStub(t)
+	cacheMap := NewCacheRedis("key-digest").SetRedisAddr(stub.Addr()).SetKeyPerEntry(true)
+	executeCount := 0
+	sum := CacheFn1(func(nums []int) int {
+		executeCount++
+		return len(nums)
+	}, &Config{CacheMap: cacheMap, HashKeyDigest: sha256.New})
+
+	nums := make([]int, 1000)
+	AssertEqual(t, sum(nums), 1000)
+	AssertEqual(t, sum(nums), 1000)
+	AssertEqual(t, executeCount, 1)
+
+	digest := sha256.Sum256([]byte(hashKey(nums)))
+	if stub.PTTL(cacheMap.entryKey(string(digest[:]))) == -2 {
+		t.Fatal("the key should be digested")
+	}
+	if stub.PTTL(cacheMap.entryKey(hashKey(nums))) != -2 {
+		t.Fatal("the dumped key should not be stored")
+	}
+
+	// LoadMany derives keys with the same digest
+	for _, m := range []CacheMapMultiLoader{cacheMap, NewCacheTiered(newCacheMapMem(0), cacheMap).SetHashKeyDigest(sha256.New).(*tieredCacheMap)} {
+		results, err := m.LoadMany([]any{nums, []int{1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := DecodeLoadResult[int](results[0]); !results[0].HasCache || err != nil || v != 1000 {
+			t.Fatalf("unexpected result %+v: %d(%v)", results[0], v, err)
+		}
+		AssertEqual(t, results[1].HasCache, false)
+	}
+}
@@ -0,0 +1,319 @@
+package gofnext
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ahuigo/gofnext/serial"
+)
+
+// shardedVirtualNodes is the number of virtual nodes of each node on the hash ring
+const shardedVirtualNodes = 160
+
+// CacheMapHealth is implemented by CacheMaps which report their health(e.g. NewCacheBreaker), dead nodes are skipped by NewCacheShardedRemote.
+type CacheMapHealth interface {
+	Healthy() bool
+}
+
+type ringPoint struct {
+	hash uint64
+	name string
+}
+
+/*
+shardedCacheMap spreads entries over several remote nodes(e.g. redis) by consistent hashing with virtual nodes,
+so adding/removing a node only remaps a minimal fraction of keys.
+Unhealthy nodes(see CacheMapHealth) are skipped: their keys are moved to the next node on the ring.
+*/
+type shardedCacheMap struct {
+	mu       sync.RWMutex
+	nodes    map[string]CacheMap
+	ring     []ringPoint
+	ttl      time.Duration
+	errTtl   time.Duration
+	reuseTtl time.Duration
+	codec    Codec
+	schema   string
+
+	keyDigest func() hash.Hash // see SetHashKeyDigest
+}
+
+/*
+NewCacheShardedRemote creates a sharded CacheMap, nodes are named by their index("0", "1", ...).
+Wrap nodes with NewCacheBreaker to skip dead nodes:
+
+	cacheMap := gofnext.NewCacheShardedRemote([]gofnext.CacheMap{
+		gofnext.NewCacheBreaker(gofnext.NewCacheRedis("key").SetRedisAddr("10.0.0.1:6379"), 3, 10*time.Second),
+		gofnext.NewCacheBreaker(gofnext.NewCacheRedis("key").SetRedisAddr("10.0.0.2:6379"), 3, 10*time.Second),
+	})
+*/
+func NewCacheShardedRemote(nodes []CacheMap) *shardedCacheMap {
+	m := &shardedCacheMap{nodes: map[string]CacheMap{}}
+	for i, node := range nodes {
+		m.AddNode(strconv.Itoa(i), node)
+	}
+	return m
+}
+
+// AddNode adds(or replaces) a node by name, names should be stable across processes
+func (m *shardedCacheMap) AddNode(name string, node CacheMap) *shardedCacheMap {
+	if node == nil {
+		panic("NewCacheShardedRemote: node cannot be nil")
+	}
+	// nodes added later inherit the config
+	if m.ttl > 0 {
+		node.SetTTL(m.ttl)
+	}
+	if m.errTtl != 0 {
+		node.SetErrTTL(m.errTtl)
+	}
+	if m.reuseTtl > 0 {
+		node.SetReuseTTL(m.reuseTtl)
+	}
+	if codecMap, ok := node.(CacheMapCodec); ok && m.codec != nil {
+		codecMap.SetCodec(m.codec)
+	}
+	if schemaMap, ok := node.(CacheMapSchema); ok && m.schema != "" {
+		schemaMap.SetSchemaVersion(m.schema)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nodes[name]; !ok {
+		for i := 0; i < shardedVirtualNodes; i++ {
+			m.ring = append(m.ring, ringPoint{hash: ringHash([]byte(name + "#" + strconv.Itoa(i))), name: name})
+		}
+		sort.Slice(m.ring, func(i, j int) bool { return m.ring[i].hash < m.ring[j].hash })
+	}
+	m.nodes[name] = node
+	return m
+}
+
+// RemoveNode removes the node by name
+func (m *shardedCacheMap) RemoveNode(name string) *shardedCacheMap {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.nodes, name)
+	ring := m.ring[:0]
+	for _, p := range m.ring {
+		if p.name != name {
+			ring = append(ring, p)
+		}
+	}
+	m.ring = ring
+	return m
+}
+
+// ringHash is fnv-1a with murmur3's finalizer(fnv alone distributes similar keys poorly)
+func ringHash(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// nodeOf returns the first healthy node of key on the ring(nil if all nodes are dead)
+func (m *shardedCacheMap) nodeOf(key any) CacheMap {
+	_, node := m.pickNode(key)
+	return node
+}
+
+// pickNode is like nodeOf, and returns the name of the node
+func (m *shardedCacheMap) pickNode(key any) (name string, node CacheMap) {
+	kb, ok := key.(string)
+	if !ok {
+		kb = string(serial.Bytes(key, false))
+	}
+	hash := ringHash([]byte(kb))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := len(m.ring)
+	start := sort.Search(n, func(i int) bool { return m.ring[i].hash >= hash })
+	tried := map[string]bool{}
+	for i := 0; i < n && len(tried) < len(m.nodes); i++ {
+		name := m.ring[(start+i)%n].name
+		if tried[name] {
+			continue
+		}
+		tried[name] = true
+		node := m.nodes[name]
+		if health, ok := node.(CacheMapHealth); ok && !health.Healthy() {
+			continue
+		}
+		return name, node
+	}
+	return "", nil
+}
+
+func (m *shardedCacheMap) allNodes() []CacheMap {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes := make([]CacheMap, 0, len(m.nodes))
+	for _, node := range m.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func (m *shardedCacheMap) HashKeyFunc(key ...any) []byte {
+	for _, node := range m.allNodes() {
+		if node, ok := node.(interface{ HashKeyFunc(...any) []byte }); ok {
+			return node.HashKeyFunc(key...)
+		}
+	}
+	return dumpHashKey(key...)
+}
+
+func (m *shardedCacheMap) Store(key, value any, err error) {
+	if node := m.nodeOf(key); node != nil {
+		node.Store(key, value, err)
+	}
+}
+
+func (m *shardedCacheMap) Load(key any) (value any, hasCache, alive bool, err error) {
+	if node := m.nodeOf(key); node != nil {
+		return node.Load(key)
+	}
+	return nil, false, false, nil
+}
+
+// LoadMany groups keys by node, each node loads its keys in one round trip(see CacheMapMultiLoader)
+func (m *shardedCacheMap) LoadMany(args []any) ([]CacheLoadResult, error) {
+	return loadMany(m, m.keyDigest, args)
+}
+
+func (m *shardedCacheMap) loadKeys(keys []any) ([]CacheLoadResult, error) {
+	results := make([]CacheLoadResult, len(keys))
+	groups := map[CacheMap][]int{}
+	for i, key := range keys {
+		if node := m.nodeOf(key); node != nil {
+			groups[node] = append(groups[node], i)
+		}
+	}
+	var firstErr error
+	for node, idxs := range groups {
+		nodeKeys := make([]any, len(idxs))
+		for j, i := range idxs {
+			nodeKeys[j] = keys[i]
+		}
+		nodeResults, err := loadKeysOf(node, nodeKeys)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for j, r := range nodeResults {
+			results[idxs[j]] = r
+		}
+	}
+	return results, firstErr
+}
+
+// Lock acquires the lock of the node which owns key
+func (m *shardedCacheMap) Lock(key any) (unlock func(), acquired bool) {
+	if locker, ok := m.nodeOf(key).(CacheMapLocker); ok {
+		return locker.Lock(key)
+	}
+	return func() {}, true
+}
+
+// Delete deletes key from all nodes(key may be stored on another node while its owner was dead)
+func (m *shardedCacheMap) Delete(key any) {
+	for _, node := range m.allNodes() {
+		if node, ok := node.(CacheMapDeleter); ok {
+			node.Delete(key)
+		}
+	}
+}
+
+func (m *shardedCacheMap) Clear() {
+	for _, node := range m.allNodes() {
+		if node, ok := node.(CacheMapDeleter); ok {
+			node.Clear()
+		}
+	}
+}
+
+func (m *shardedCacheMap) SetCodec(codec Codec) CacheMap {
+	m.codec = codec
+	for _, node := range m.allNodes() {
+		if node, ok := node.(CacheMapCodec); ok {
+			node.SetCodec(codec)
+		}
+	}
+	return m
+}
+
+func (m *shardedCacheMap) SetSchemaVersion(version string) CacheMap {
+	m.schema = version
+	for _, node := range m.allNodes() {
+		if node, ok := node.(CacheMapSchema); ok {
+			node.SetSchemaVersion(version)
+		}
+	}
+	return m
+}
+
+// SetHashKeyDigest sets the digest of keys derived by LoadMany(see Config.HashKeyDigest)
+func (m *shardedCacheMap) SetHashKeyDigest(digest func() hash.Hash) CacheMap {
+	m.keyDigest = digest
+	return m
+}
+
+func (m *shardedCacheMap) SchemaVersion() string {
+	return m.schema
+}
+
+func (m *shardedCacheMap) SetTTL(ttl time.Duration) CacheMap {
+	m.ttl = ttl
+	for _, node := range m.allNodes() {
+		node.SetTTL(ttl)
+	}
+	return m
+}
+
+func (m *shardedCacheMap) SetErrTTL(errTTL time.Duration) CacheMap {
+	m.errTtl = errTTL
+	for _, node := range m.allNodes() {
+		node.SetErrTTL(errTTL)
+	}
+	return m
+}
+
+func (m *shardedCacheMap) SetReuseTTL(ttl time.Duration) CacheMap {
+	m.reuseTtl = ttl
+	for _, node := range m.allNodes() {
+		node.SetReuseTTL(ttl)
+	}
+	return m
+}
+
+func (m *shardedCacheMap) NeedMarshal() bool {
+	for _, node := range m.allNodes() {
+		if node.NeedMarshal() {
+			return true
+		}
+	}
+	return false
+}
+
+// BackendKey returns the node's name and the node's backend key, e.g. "1:key"
+func (m *shardedCacheMap) BackendKey(key any) string {
+	name, node := m.pickNode(key)
+	if node == nil {
+		return ""
+	}
+	if node, ok := node.(CacheMapBackendKey); ok {
+		return name + ":" + node.BackendKey(key)
+	}
+	return name + ":" + fmt.Sprint(key)
+}
@@ -0,0 +1,101 @@
+package gofnext
+
+import (
+	"fmt"
+	"testing"
+)
+
+// healthNode is an in-memory stand-in of remote node
+type healthNode struct {
+	*memCacheMap
+	dead bool
+}
+
+func (n *healthNode) Healthy() bool { return !n.dead }
+
+func newHealthNodes(n int) []CacheMap {
+	nodes := make([]CacheMap, n)
+	for i := range nodes {
+		nodes[i] = &healthNode{memCacheMap: newCacheMapMem(0)}
+	}
+	return nodes
+}
+
+func TestCacheShardedRemote_Distribution(t *testing.T) {
+	nodes := newHealthNodes(4)
+	m := NewCacheShardedRemote(nodes)
+	for i := 0; i < 4000; i++ {
+		v := i
+		m.Store(fmt.Sprintf("key%d", i), &v, nil)
+	}
+	for i, node := range nodes {
+		n := 0
+		node.(*healthNode).memCacheMap.Range(func(key, value any) bool { n++; return true })
+		if n < 500 || n > 1500 {
+			t.Fatalf("node %d has %d keys, keys are not spread evenly", i, n)
+		}
+	}
+	value, hasCache, _, _ := m.Load("key1")
+	if !hasCache || *value.(*int) != 1 {
+		t.Fatalf("unexpected value %v", value)
+	}
+}
+
+func TestCacheShardedRemote_Remap(t *testing.T) {
+	m := NewCacheShardedRemote(newHealthNodes(4))
+	owners := map[string]CacheMap{}
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owners[key] = m.nodeOf(key)
+	}
+
+	// adding a node only remaps about 1/5 keys to the new node
+	newNode := newHealthNodes(1)[0]
+	m.AddNode("4", newNode)
+	moved := 0
+	for key, owner := range owners {
+		if node := m.nodeOf(key); node != owner {
+			moved++
+			if node != newNode {
+				t.Fatalf("key %s should be moved to the new node", key)
+			}
+		}
+	}
+	if moved < 400 || moved > 1400 {
+		t.Fatalf("unexpected moved keys: %d", moved)
+	}
+
+	// removing the node restores the original owners
+	m.RemoveNode("4")
+	for key, owner := range owners {
+		if m.nodeOf(key) != owner {
+			t.Fatalf("key %s should be moved back", key)
+		}
+	}
+}
+
+func TestCacheShardedRemote_SkipDeadNode(t *testing.T) {
+	nodes := newHealthNodes(3)
+	m := NewCacheShardedRemote(nodes)
+	executeCount := 0
+	getNum := CacheFn1(func(i int) int {
+		executeCount++
+		return i * 2
+	}, &Config{CacheMap: m})
+
+	owner := m.nodeOf("1").(*healthNode)
+	owner.dead = true
+	if m.nodeOf("1") == owner {
+		t.Fatal("dead node should be skipped")
+	}
+	AssertEqual(t, getNum(1), 2)
+	AssertEqual(t, getNum(1), 2)
+	AssertEqual(t, executeCount, 1)
+
+	// all nodes are dead: call the function directly
+	for _, node := range nodes {
+		node.(*healthNode).dead = true
+	}
+	AssertEqual(t, getNum(1), 2)
+	AssertEqual(t, executeCount, 2)
+}
@@ -0,0 +1,212 @@
+package gofnext
+
+import (
+	"hash"
+	"time"
+)
+
+/*
+tieredCacheMap reads from l1 first, then falls back to l2.
+  - On l2 hits, the value is back-filled into l1(with l1's TTL), marshaled value is back-filled as is.
+  - Store writes through to both l1 and l2.
+
+l1 is usually a memory CacheMap, and l2 is usually a remote CacheMap(e.g. redis).
+*/
+type tieredCacheMap struct {
+	l1    CacheMap
+	l2    CacheMap
+	ttl   time.Duration
+	l1Ttl time.Duration
+
+	keyDigest func() hash.Hash // see SetHashKeyDigest
+}
+
+func NewCacheTiered(l1, l2 CacheMap) *tieredCacheMap {
+	if l1 == nil || l2 == nil {
+		panic("NewCacheTiered: l1 and l2 cannot be nil")
+	}
+	return &tieredCacheMap{
+		l1: l1,
+		l2: l2,
+	}
+}
+
+// SetL1TTL sets a shorter TTL for l1, so that l1 will not serve stale values for too long.
+func (m *tieredCacheMap) SetL1TTL(ttl time.Duration) *tieredCacheMap {
+	m.l1Ttl = ttl
+	m.l1.SetTTL(m.getL1TTL())
+	return m
+}
+
+func (m *tieredCacheMap) getL1TTL() time.Duration {
+	if m.l1Ttl > 0 && (m.ttl == 0 || m.l1Ttl < m.ttl) {
+		return m.l1Ttl
+	}
+	return m.ttl
+}
+
+func (m *tieredCacheMap) HashKeyFunc(key ...any) []byte {
+	if l2, ok := m.l2.(interface{ HashKeyFunc(...any) []byte }); ok {
+		return l2.HashKeyFunc(key...)
+	}
+	return dumpHashKey(key...)
+}
+
+// BackendKey returns the backend key of l2(empty if l2 has no backend key)
+func (m *tieredCacheMap) BackendKey(key any) string {
+	if l2, ok := m.l2.(CacheMapBackendKey); ok {
+		return l2.BackendKey(key)
+	}
+	return ""
+}
+
+// Lock forwards to the distributed lock of l2(see CacheMapLocker), so that only one replica executes the function
+func (m *tieredCacheMap) Lock(key any) (unlock func(), acquired bool) {
+	if locker, ok := m.l2.(CacheMapLocker); ok {
+		return locker.Lock(key)
+	}
+	return func() {}, true
+}
+
+func (m *tieredCacheMap) Store(key, value any, err error) {
+	m.l1.Store(key, value, err)
+	m.l2.Store(key, value, err)
+}
+
+func (m *tieredCacheMap) Load(key any) (value any, hasCache, alive bool, err error) {
+	// 1. l1 cache is valid
+	value, hasCache, alive, err = m.l1.Load(key)
+	if hasCache && alive {
+		return value, hasCache, alive, err
+	}
+
+	// 2. l2 cache exists
+	value2, hasCache2, alive2, err2 := m.l2.Load(key)
+	if hasCache2 {
+		if alive2 {
+			m.l1.Store(key, value2, err2)
+		}
+		return value2, hasCache2, alive2, err2
+	}
+
+	// 3. reuse l1's dead cache
+	if hasCache {
+		return value, hasCache, alive, err
+	}
+	return nil, false, false, nil
+}
+
+// LoadMany loads keys from l1 first, then loads the rest from l2 in one round trip(see CacheMapMultiLoader)
+func (m *tieredCacheMap) LoadMany(args []any) ([]CacheLoadResult, error) {
+	return loadMany(m, m.keyDigest, args)
+}
+
+func (m *tieredCacheMap) loadKeys(keys []any) ([]CacheLoadResult, error) {
+	results := make([]CacheLoadResult, len(keys))
+	var missKeys []any
+	var missIdxs []int
+	for i, key := range keys {
+		r := &results[i]
+		r.Value, r.HasCache, r.Alive, r.Err = m.l1.Load(key)
+		if !r.HasCache || !r.Alive {
+			missKeys = append(missKeys, key)
+			missIdxs = append(missIdxs, i)
+		}
+	}
+	if len(missKeys) == 0 {
+		return results, nil
+	}
+	l2Results, err := loadKeysOf(m.l2, missKeys)
+	for j, r := range l2Results {
+		if !r.HasCache {
+			// reuse l1's dead cache
+			continue
+		}
+		if r.Alive {
+			m.l1.Store(missKeys[j], r.Value, r.Err)
+		}
+		results[missIdxs[j]] = r
+	}
+	return results, err
+}
+
+// Delete removes the cache of key from both l1 and l2(if they are CacheMapDeleter)
+func (m *tieredCacheMap) Delete(key any) {
+	if l1, ok := m.l1.(CacheMapDeleter); ok {
+		l1.Delete(key)
+	}
+	if l2, ok := m.l2.(CacheMapDeleter); ok {
+		l2.Delete(key)
+	}
+}
+
+// Clear removes all caches from both l1 and l2(if they are CacheMapDeleter)
+func (m *tieredCacheMap) Clear() {
+	if l1, ok := m.l1.(CacheMapDeleter); ok {
+		l1.Clear()
+	}
+	if l2, ok := m.l2.(CacheMapDeleter); ok {
+		l2.Clear()
+	}
+}
+
+func (m *tieredCacheMap) SetCodec(codec Codec) CacheMap {
+	if l1, ok := m.l1.(CacheMapCodec); ok {
+		l1.SetCodec(codec)
+	}
+	if l2, ok := m.l2.(CacheMapCodec); ok {
+		l2.SetCodec(codec)
+	}
+	return m
+}
+
+func (m *tieredCacheMap) SetSchemaVersion(version string) CacheMap {
+	if l1, ok := m.l1.(CacheMapSchema); ok {
+		l1.SetSchemaVersion(version)
+	}
+	if l2, ok := m.l2.(CacheMapSchema); ok {
+		l2.SetSchemaVersion(version)
+	}
+	return m
+}
+
+// SetHashKeyDigest sets the digest of keys derived by LoadMany(see Config.HashKeyDigest)
+func (m *tieredCacheMap) SetHashKeyDigest(digest func() hash.Hash) CacheMap {
+	m.keyDigest = digest
+	return m
+}
+
+// SchemaVersion returns the schema version of l2(or l1)
+func (m *tieredCacheMap) SchemaVersion() string {
+	if l2, ok := m.l2.(cacheMapSchemaVersion); ok {
+		return l2.SchemaVersion()
+	}
+	if l1, ok := m.l1.(cacheMapSchemaVersion); ok {
+		return l1.SchemaVersion()
+	}
+	return ""
+}
+
+func (m *tieredCacheMap) SetTTL(ttl time.Duration) CacheMap {
+	m.ttl = ttl
+	m.l1.SetTTL(m.getL1TTL())
+	m.l2.SetTTL(ttl)
+	return m
+}
+
+func (m *tieredCacheMap) SetErrTTL(errTTL time.Duration) CacheMap {
+	m.l1.SetErrTTL(errTTL)
+	m.l2.SetErrTTL(errTTL)
+	return m
+}
+
+func (m *tieredCacheMap) SetReuseTTL(ttl time.Duration) CacheMap {
+	m.l1.SetReuseTTL(ttl)
+	m.l2.SetReuseTTL(ttl)
+	return m
+}
+
+// NeedMarshal returns true if any tier needs marshaling, values loaded may be either marshaled bytes or original values.
+func (m *tieredCacheMap) NeedMarshal() bool {
+	return m.l1.NeedMarshal() || m.l2.NeedMarshal()
+}
@@ -0,0 +1,99 @@
+package gofnext
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheTiered_Backfill(t *testing.T) {
+	// l2 is shared by two instances(arena is a marshaling CacheMap like redis)
+	l2 := NewCacheArena(1 << 20)
+	l1a := newCacheMapMem(0)
+	l1b := newCacheMapMem(0)
+	executeCount := 0
+	getUser := func(id int) (map[string]int, error) {
+		executeCount++
+		return map[string]int{"id": id}, nil
+	}
+	getUserA := CacheFn1Err(getUser, &Config{CacheMap: NewCacheTiered(l1a, l2)})
+	getUserB := CacheFn1Err(getUser, &Config{CacheMap: NewCacheTiered(l1b, l2)})
+
+	// 1. write through to l1a and l2
+	user, _ := getUserA(1)
+	AssertEqual(t, user["id"], 1)
+	if _, hasCache, _, _ := l1a.Load(hashKey(1)); !hasCache {
+		t.Fatal("l1a should have cache")
+	}
+
+	// 2. l2 hit: back-fill l1b
+	user, _ = getUserB(1)
+	AssertEqual(t, user["id"], 1)
+	value, hasCache, _, _ := l1b.Load(hashKey(1))
+	if !hasCache {
+		t.Fatal("l1b should be back-filled")
+	}
+	if _, ok := value.(*encodedValue); !ok {
+		t.Fatalf("l1b should store marshaled bytes, got %T", value)
+	}
+
+	// 3. l1 hit(back-filled value)
+	user, _ = getUserB(1)
+	AssertEqual(t, user["id"], 1)
+	AssertEqual(t, executeCount, 1)
+}
+
+func TestCacheTiered_L1TTL(t *testing.T) {
+	l1 := newCacheMapMem(0)
+	l2 := NewCacheArena(1 << 20)
+	m := NewCacheTiered(l1, l2).SetL1TTL(10 * time.Millisecond)
+	m.SetTTL(time.Hour)
+	AssertEqual(t, l1.ttl, 10*time.Millisecond)
+	AssertEqual(t, l2.ttl, time.Hour)
+
+	v := 1
+	m.Store("k", &v, nil)
+	time.Sleep(20 * time.Millisecond)
+
+	// l1 is expired, l2 is still alive
+	if _, hasCache, _, _ := l1.Load("k"); hasCache {
+		t.Fatal("l1 should be expired")
+	}
+	value, hasCache, alive, _ := m.Load("k")
+	if !(hasCache && alive) {
+		t.Fatal("l2 should be alive")
+	}
+	if _, ok := value.(*encodedValue); !ok {
+		t.Fatalf("l2 value should be encoded, got %T", value)
+	}
+	if !m.NeedMarshal() {
+		t.Fatal("tiered map should need marshal")
+	}
+}
+
+func TestCacheTiered_LoadMany(t *testing.T) {
+	// LoadMany is forwarded through tiered and breaker CacheMaps
+	l2 := NewCacheRedis("tiered-load-many").SetRedisClient(newFakeRedisClient())
+	getNum := CacheFn1(func(i int) int { return i * 10 }, &Config{CacheMap: NewCacheTiered(newCacheMapMem(0), l2)})
+	getNum(1)
+	getNum(2)
+
+	l1 := newCacheMapMem(0)
+	for _, cacheMap := range []CacheMapMultiLoader{NewCacheTiered(l1, l2), NewCacheBreaker(l2, 3, time.Second)} {
+		results, err := cacheMap.LoadMany([]any{1, 2, 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, r := range results[:2] {
+			if v, err := DecodeLoadResult[int](r); !r.HasCache || err != nil || v != (i+1)*10 {
+				t.Fatalf("unexpected result %+v: %d(%v)", r, v, err)
+			}
+		}
+		if results[2].HasCache {
+			t.Fatal("key 3 should not exist")
+		}
+	}
+	// l2 hits are back-filled into l1
+	if _, hasCache, _, _ := l1.Load(hashKey(1)); !hasCache {
+		t.Fatal("l1 should have cache")
+	}
+}
@@ -1,6 +1,11 @@
 package gofnext
 
-import "time"
+import (
+	"hash"
+	"time"
+
+	"github.com/ahuigo/gofnext/serial"
+)
 
 type CacheMap interface {
 	// Goroutine concurrently on **same key**.
@@ -16,3 +21,78 @@ type CacheMap interface {
 	SetReuseTTL(ttl time.Duration) CacheMap
 	NeedMarshal() bool
 }
+
+// CacheMapDeleter is implemented by CacheMaps which support invalidating cache.
+type CacheMapDeleter interface {
+	// Delete removes the cache of key
+	Delete(key any)
+	// Clear removes all caches
+	Clear()
+}
+
+// CacheMapLocker is implemented by CacheMaps which support cross-process locks(e.g. redisMap.SetDistributedLock)
+type CacheMapLocker interface {
+	/*
+	   Lock acquires the lock of key before executing the function:
+	   acquired: the caller should execute the function, and call unlock after storing the value
+	   !acquired: another process has stored the fresh value, the caller should load it
+	*/
+	Lock(key any) (unlock func(), acquired bool)
+}
+
+// CacheMapCodec is implemented by CacheMaps which support custom codec(see Config.Codec)
+type CacheMapCodec interface {
+	SetCodec(codec Codec) CacheMap
+}
+
+// CacheMapSchema is implemented by CacheMaps which store the schema version in the envelope(see Config.SchemaVersion)
+type CacheMapSchema interface {
+	SetSchemaVersion(version string) CacheMap
+}
+
+// cacheMapSchemaVersion reports the schema version set by SetSchemaVersion(used if Config.SchemaVersion is empty)
+type cacheMapSchemaVersion interface {
+	SchemaVersion() string
+}
+
+// CacheMapKeyDigest is implemented by CacheMaps whose LoadMany derives keys like the decorator(see Config.HashKeyDigest)
+type CacheMapKeyDigest interface {
+	SetHashKeyDigest(digest func() hash.Hash) CacheMap
+}
+
+// CacheMapChecked is implemented by remote CacheMaps which report backend errors(see NewCacheBreaker)
+type CacheMapChecked interface {
+	LoadChecked(key any) (value any, hasCache, alive bool, err, backendErr error)
+	StoreChecked(key, value any, err error) (backendErr error)
+}
+
+// CacheLoadResult is the result of CacheMap.Load, its value can be decoded by DecodeLoadResult
+type CacheLoadResult struct {
+	Value    any
+	HasCache bool
+	Alive    bool
+	Err      error
+}
+
+/*
+CacheMapMultiLoader is implemented by remote CacheMaps which load the cache of many calls in one round trip(e.g. redisMap.LoadMany).
+args are the arguments of one-parameter functions(use []any{a, b} for a function with several parameters),
+their keys are derived by HashKeyFunc of the CacheMap like the decorator.
+An error is returned if a key can't be derived or the backend fails.
+*/
+type CacheMapMultiLoader interface {
+	LoadMany(args []any) ([]CacheLoadResult, error)
+}
+
+// cacheMapKeysLoader loads the keys derived by the decorator in one round trip(see CacheMapMultiLoader)
+type cacheMapKeysLoader interface {
+	loadKeys(keys []any) ([]CacheLoadResult, error)
+}
+
+// CacheMapBackendKey is implemented by CacheMaps which derive their own keys from the key(see CacheHandle.ExplainKey)
+type CacheMapBackendKey interface {
+	BackendKey(key any) string
+}
+
+// CacheKeyer is implemented by argument types which define their own cache key(see serial.CacheKeyer)
+type CacheKeyer = serial.CacheKeyer
@@ -0,0 +1,109 @@
+package gofnext
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	compressSuffix = "+flate"
+
+	// header byte of compressed codec
+	compressHeaderRaw   = 0
+	compressHeaderFlate = 1
+)
+
+/*
+NewCodecCompress wraps codec with flate compression, values smaller than threshold bytes stay raw.
+A header byte is written before the data, and codec's name is tagged with "+flate"(e.g. "msgpack+flate"),
+so that compressed and uncompressed entries can coexist during rollout:
+
+	cacheMap := gofnext.NewCacheRedis("key").SetCodec(gofnext.NewCodecCompress(gofnext.CodecMsgpack, 1024))
+*/
+func NewCodecCompress(codec Codec, threshold int) Codec {
+	return &compressCodec{codec: codec, threshold: threshold}
+}
+
+type compressCodec struct {
+	codec     Codec
+	threshold int
+}
+
+var flateWriterPool = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+func (c *compressCodec) Name() string { return c.codec.Name() + compressSuffix }
+
+func (c *compressCodec) Marshal(v any) ([]byte, error) {
+	return c.marshalKey(v, nil)
+}
+
+func (c *compressCodec) marshalKey(v any, key []byte) ([]byte, error) {
+	data, err := marshalWithKey(c.codec, v, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < c.threshold {
+		return append([]byte{compressHeaderRaw}, data...), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(compressHeaderFlate)
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() > len(data) {
+		// incompressible data
+		return append([]byte{compressHeaderRaw}, data...), nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *compressCodec) Unmarshal(data []byte, v any) error {
+	return c.unmarshalKey(data, v, nil)
+}
+
+func (c *compressCodec) unmarshalKey(data []byte, v any, key []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("gofnext: empty compressed data")
+	}
+	switch data[0] {
+	case compressHeaderRaw:
+		return unmarshalWithKey(c.codec, data[1:], v, key)
+	case compressHeaderFlate:
+		r := flate.NewReader(bytes.NewReader(data[1:]))
+		defer r.Close()
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return unmarshalWithKey(c.codec, raw, v, key)
+	}
+	return fmt.Errorf("gofnext: unknown compression header %d", data[0])
+}
+
+// getCompressCodec returns compressed codec by tagged name(the threshold is only used by Marshal)
+func getCompressCodec(name string) (Codec, bool) {
+	base, ok := strings.CutSuffix(name, compressSuffix)
+	if !ok {
+		return nil, false
+	}
+	codec, err := getCodec(base)
+	if err != nil {
+		return nil, false
+	}
+	return NewCodecCompress(codec, 0), true
+}
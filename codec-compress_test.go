@@ -0,0 +1,59 @@
+package gofnext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodecCompress(t *testing.T) {
+	client := newFakeRedisClient()
+	codec := NewCodecCompress(CodecJSON, 64)
+	getText := CacheFn1(func(n int) string {
+		return strings.Repeat("a", n)
+	}, &Config{
+		CacheMap: NewCacheRedis("codec-compress").SetRedisClient(client),
+		Codec:    codec,
+	})
+	for _, n := range []int{10, 10000} {
+		getText(n)
+		AssertEqual(t, getText(n), strings.Repeat("a", n))
+	}
+
+	readData := func(key string) redisData {
+		cacheData := redisData{}
+		if err := unmarshalMsgpack(client.hashes["_gofnext:codec-compress"][key], &cacheData); err != nil {
+			t.Fatal(err)
+		}
+		return cacheData
+	}
+	// tiny value stays raw
+	small := readData(hashKey(10))
+	AssertEqual(t, small.Codec, "json+flate")
+	AssertEqual(t, small.Data[0], byte(compressHeaderRaw))
+	// large value is compressed
+	large := readData(hashKey(10000))
+	AssertEqual(t, large.Data[0], byte(compressHeaderFlate))
+	if len(large.Data) > 1000 {
+		t.Fatalf("value should be compressed, got %d bytes", len(large.Data))
+	}
+}
+
+func TestCodecCompress_Rollout(t *testing.T) {
+	// uncompressed entries can be read after enabling compression, and vice versa
+	client := newFakeRedisClient()
+	oldMap := NewCacheRedis("compress-rollout").SetRedisClient(client)
+	newMap := NewCacheRedis("compress-rollout").SetRedisClient(client).SetCodec(NewCodecCompress(CodecMsgpack, 0))
+	v1, v2 := "old", "new"
+	oldMap.Store("1", &v1, nil)
+	newMap.Store("2", &v2, nil)
+	for key, want := range map[string]string{"1": v1, "2": v2} {
+		for _, m := range []CacheMap{oldMap, newMap} {
+			value, hasCache, _, _ := m.Load(key)
+			var got string
+			if err := value.(*encodedValue).decode(&got); !hasCache || err != nil {
+				t.Fatalf("cache %s should be decoded: %v", key, err)
+			}
+			AssertEqual(t, got, want)
+		}
+	}
+}
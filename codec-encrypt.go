@@ -0,0 +1,110 @@
+package gofnext
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+const encryptSuffix = "+aesgcm"
+
+// errCacheMiss is returned when a cached value cannot be decoded(e.g. decryption fails), the function is executed again
+var errCacheMiss = errors.New("gofnext: cache miss")
+
+/*
+NewCodecEncrypt wraps codec with AES-GCM encryption(keys should be 16, 24 or 32 bytes).
+Values are encrypted with the key of currentKeyID, and the key id is written into the envelope,
+so that values encrypted with old keys can still be decrypted after key rotation.
+A value which cannot be decrypted is treated as a cache miss.
+
+	codec := gofnext.NewCodecEncrypt(gofnext.CodecMsgpack, "k2", map[string][]byte{"k1": oldKey, "k2": newKey})
+	cacheMap := gofnext.NewCacheRedis("key").SetCodec(codec)
+
+The codec is not registered globally(see RegisterCodec): values are only decrypted by the CacheMaps it is set on.
+The cache key is authenticated as additional data, so a value copied to another key can't be decrypted.
+*/
+func NewCodecEncrypt(codec Codec, currentKeyID string, keys map[string][]byte) Codec {
+	if len(currentKeyID) > 255 {
+		panic("gofnext: key id is too long")
+	}
+	c := &encryptCodec{codec: codec, currentKeyID: currentKeyID, aeads: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			panic(fmt.Sprintf("gofnext: invalid key %q: %v", id, err))
+		}
+		c.aeads[id], _ = cipher.NewGCM(block)
+	}
+	if c.aeads[currentKeyID] == nil {
+		panic(fmt.Sprintf("gofnext: current key %q is not found", currentKeyID))
+	}
+	return c
+}
+
+/*
+encryptCodec encrypts the data produced by codec:
+
+	keyIDLen(1) | keyID | nonce(12) | ciphertext
+*/
+type encryptCodec struct {
+	codec        Codec
+	currentKeyID string
+	aeads        map[string]cipher.AEAD // key id -> aead
+}
+
+func (c *encryptCodec) Name() string { return c.codec.Name() + encryptSuffix }
+
+func (c *encryptCodec) Marshal(v any) ([]byte, error) {
+	return c.marshalKey(v, nil)
+}
+
+func (c *encryptCodec) marshalKey(v any, key []byte) ([]byte, error) {
+	data, err := marshalWithKey(c.codec, v, key)
+	if err != nil {
+		return nil, err
+	}
+	aead := c.aeads[c.currentKeyID]
+	buf := make([]byte, 0, 1+len(c.currentKeyID)+aead.NonceSize()+len(data)+aead.Overhead())
+	buf = append(buf, byte(len(c.currentKeyID)))
+	buf = append(buf, c.currentKeyID...)
+	nonceStart := len(buf)
+	buf = buf[:nonceStart+aead.NonceSize()]
+	if _, err := rand.Read(buf[nonceStart:]); err != nil {
+		return nil, err
+	}
+	// the key id and the cache key are authenticated as additional data
+	ad := append(buf[:nonceStart:nonceStart], key...)
+	return aead.Seal(buf, buf[nonceStart:], data, ad), nil
+}
+
+func (c *encryptCodec) Unmarshal(data []byte, v any) error {
+	return c.unmarshalKey(data, v, nil)
+}
+
+func (c *encryptCodec) unmarshalKey(data []byte, v any, key []byte) error {
+	data, err := c.decrypt(data, key)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errCacheMiss, err)
+	}
+	return unmarshalWithKey(c.codec, data, v, key)
+}
+
+func (c *encryptCodec) decrypt(data, key []byte) ([]byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, errors.New("invalid encrypted data")
+	}
+	keyID := string(data[1 : 1+data[0]])
+	aead := c.aeads[keyID]
+	if aead == nil {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	nonceStart := 1 + len(keyID)
+	if len(data) < nonceStart+aead.NonceSize() {
+		return nil, errors.New("invalid encrypted data")
+	}
+	nonce := data[nonceStart : nonceStart+aead.NonceSize()]
+	ad := append(data[:nonceStart:nonceStart], key...)
+	return aead.Open(nil, nonce, data[nonceStart+aead.NonceSize():], ad)
+}
@@ -0,0 +1,97 @@
+package gofnext
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCodecEncrypt_Rotation(t *testing.T) {
+	key1, key2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	client := newFakeRedisClient()
+	executeCount := 0
+	getPhone := func(codec Codec) func(int) string {
+		return CacheFn1(func(id int) string {
+			executeCount++
+			return "+86-10086"
+		}, &Config{
+			CacheMap: NewCacheRedis("codec-encrypt").SetRedisClient(client),
+			Codec:    codec,
+		})
+	}
+
+	// 1. encrypt with k1
+	getPhoneV1 := getPhone(NewCodecEncrypt(CodecMsgpack, "k1", map[string][]byte{"k1": key1}))
+	getPhoneV1(1)
+	AssertEqual(t, getPhoneV1(1), "+86-10086")
+	AssertEqual(t, executeCount, 1)
+	raw := client.hashes["_gofnext:codec-encrypt"][hashKey(1)]
+	if bytes.Contains(raw, []byte("10086")) {
+		t.Fatal("value should be encrypted")
+	}
+
+	// 2. rotate key: decrypt with old key, encrypt with new key
+	getPhoneV2 := getPhone(NewCodecEncrypt(CodecMsgpack, "k2", map[string][]byte{"k1": key1, "k2": key2}))
+	AssertEqual(t, getPhoneV2(1), "+86-10086")
+	AssertEqual(t, getPhoneV2(2), "+86-10086")
+	AssertEqual(t, executeCount, 2)
+
+	// 3. old key is removed: decryption failure is a cache miss
+	getPhoneV3 := getPhone(NewCodecEncrypt(CodecMsgpack, "k2", map[string][]byte{"k2": key2}))
+	AssertEqual(t, getPhoneV3(1), "+86-10086")
+	AssertEqual(t, executeCount, 3)
+	AssertEqual(t, getPhoneV3(1), "+86-10086")
+	AssertEqual(t, executeCount, 3)
+}
+
+func TestCodecEncrypt_Tampered(t *testing.T) {
+	codec := NewCodecEncrypt(CodecJSON, "k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 16)})
+	encoded, err := marshalValue("key", "secret", codec, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded.local, encoded.key = codec, codecKey("key")
+	var v string
+	if err := encoded.decode(&v); err != nil || v != "secret" {
+		t.Fatalf("unexpected value %q(%v)", v, err)
+	}
+
+	// the value is bound to its key
+	encoded.key = codecKey("other")
+	if err := encoded.decode(&v); !errors.Is(err, errCacheMiss) {
+		t.Fatalf("value of another key should not be decrypted: %v", err)
+	}
+	encoded.key = codecKey("key")
+	encoded.data[len(encoded.data)-1] ^= 1
+	if err := encoded.decode(&v); err == nil {
+		t.Fatal("tampered value should not be decrypted")
+	}
+}
+
+func TestCodecEncrypt_Isolated(t *testing.T) {
+	// codecs with different keys(and the same name) don't break each other
+	client := newFakeRedisClient()
+	executeCount := 0
+	getPhone := func(name string, key []byte) func(int) string {
+		return CacheFn1(func(id int) string {
+			executeCount++
+			return "+86-10086"
+		}, &Config{
+			CacheMap: NewCacheRedis(name).SetRedisClient(client),
+			Codec:    NewCodecEncrypt(CodecMsgpack, "k1", map[string][]byte{"k1": key}),
+		})
+	}
+	getPhoneA := getPhone("codec-encrypt-a", bytes.Repeat([]byte{1}, 16))
+	getPhoneB := getPhone("codec-encrypt-b", bytes.Repeat([]byte{2}, 16))
+	for i := 0; i < 3; i++ {
+		AssertEqual(t, getPhoneA(1), "+86-10086")
+		AssertEqual(t, getPhoneB(1), "+86-10086")
+	}
+	AssertEqual(t, executeCount, 2)
+
+	// a value copied to another key is not decrypted
+	hash := client.hashes["_gofnext:codec-encrypt-a"]
+	hash[hashKey(2)] = hash[hashKey(1)]
+	AssertEqual(t, getPhoneA(2), "+86-10086")
+	AssertEqual(t, executeCount, 3)
+}
@@ -0,0 +1,192 @@
+package gofnext
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+/*
+CachedError is an error restored from CacheMaps which need marshaling(e.g. redis).
+If the original error(or an error it wraps) is registered by RegisterError or RegisterErrorType,
+it can be found by errors.Is/errors.As through Unwrap.
+*/
+type CachedError struct {
+	Msg  string
+	Type string // type name of the original error, e.g. *fs.PathError
+	err  error  // the registered error restored from cache
+}
+
+func (e *CachedError) Error() string { return e.Msg }
+func (e *CachedError) Unwrap() error { return e.err }
+
+type errorType struct {
+	name  string
+	codec Codec
+	match func(err error) (any, bool) // returns *E
+
+	decode func(data []byte, codec Codec) (error, error)
+}
+
+var errorRegistry = struct {
+	sync.RWMutex
+	sentinels     map[string]error
+	sentinelNames []string
+	types         map[string]*errorType
+	typeNames     []string
+}{
+	sentinels: map[string]error{},
+	types:     map[string]*errorType{},
+}
+
+func sentinelName(err error) string {
+	return fmt.Sprintf("%T:%s", err, err.Error())
+}
+
+/*
+RegisterError registers sentinel errors(e.g. sql.ErrNoRows), so that errors.Is works for cached errors.
+Sentinels are identified by type and message, it panics if another sentinel has the same identity
+(e.g. errors.New("not found") of two packages), register one of them by RegisterErrorName instead.
+*/
+func RegisterError(errs ...error) {
+	for _, err := range errs {
+		RegisterErrorName(sentinelName(err), err)
+	}
+}
+
+// RegisterErrorName registers the sentinel error with an explicit name, it panics if the name is used by another sentinel.
+func RegisterErrorName(name string, err error) {
+	errorRegistry.Lock()
+	defer errorRegistry.Unlock()
+	if registered, ok := errorRegistry.sentinels[name]; ok {
+		if registered != err {
+			panic(fmt.Sprintf("gofnext: sentinel error %q is already registered", name))
+		}
+		return
+	}
+	errorRegistry.sentinelNames = append(errorRegistry.sentinelNames, name)
+	errorRegistry.sentinels[name] = err
+}
+
+/*
+RegisterErrorType registers error type E with codec(CodecSerial by default),
+so that errors.As works for cached errors:
+
+	gofnext.RegisterErrorType[*MyErr](nil)
+*/
+func RegisterErrorType[E error](codec Codec) {
+	if codec == nil {
+		codec = CodecSerial
+	}
+	name := reflect.TypeOf((*E)(nil)).Elem().String()
+	typ := &errorType{
+		name:  name,
+		codec: codec,
+		match: func(err error) (any, bool) {
+			var target E
+			if errors.As(err, &target) {
+				return &target, true
+			}
+			return nil, false
+		},
+		decode: func(data []byte, codec Codec) (error, error) {
+			var target E
+			if err := codec.Unmarshal(data, &target); err != nil {
+				return nil, err
+			}
+			return target, nil
+		},
+	}
+	errorRegistry.Lock()
+	defer errorRegistry.Unlock()
+	if _, ok := errorRegistry.types[name]; !ok {
+		errorRegistry.typeNames = append(errorRegistry.typeNames, name)
+	}
+	errorRegistry.types[name] = typ
+}
+
+// errorData is the envelope of cached error
+type errorData struct {
+	Msg      string
+	Type     string
+	Sentinel string `msgpack:",omitempty"`
+	ErrType  string `msgpack:",omitempty"`
+	Codec    string `msgpack:",omitempty"`
+	Data     []byte `msgpack:",omitempty"`
+}
+
+// errorDataPrefix distinguishes error envelope from plain message(old cache)
+const errorDataPrefix = 0
+
+// marshalError encodes error with the identity of registered error
+func marshalError(err error) []byte {
+	data := errorData{Msg: err.Error(), Type: fmt.Sprintf("%T", err)}
+	errorRegistry.RLock()
+	for _, name := range errorRegistry.sentinelNames {
+		if errors.Is(err, errorRegistry.sentinels[name]) {
+			data.Sentinel = name
+			break
+		}
+	}
+	if data.Sentinel == "" {
+		for _, name := range errorRegistry.typeNames {
+			typ := errorRegistry.types[name]
+			if target, ok := typ.match(err); ok {
+				b, err := typ.codec.Marshal(target)
+				if err != nil {
+					slogger.Error("gofnext: marshal error", "type", name, "err", err.Error())
+					continue
+				}
+				data.ErrType, data.Codec, data.Data = name, typ.codec.Name(), b
+				break
+			}
+		}
+	}
+	errorRegistry.RUnlock()
+
+	buf, err2 := marshalMsgpack(data)
+	if err2 != nil {
+		slogger.Error("gofnext: marshal error", "err", err2.Error())
+		return []byte(data.Msg)
+	}
+	return append([]byte{errorDataPrefix}, buf...)
+}
+
+// unmarshalError restores the registered error, or returns *CachedError
+func unmarshalError(b []byte) error {
+	if len(b) == 0 || b[0] != errorDataPrefix {
+		// plain message
+		return &CachedError{Msg: string(b)}
+	}
+	data := errorData{}
+	if err := unmarshalMsgpack(b[1:], &data); err != nil {
+		return &CachedError{Msg: string(b)}
+	}
+	cachedErr := &CachedError{Msg: data.Msg, Type: data.Type}
+
+	errorRegistry.RLock()
+	sentinel := errorRegistry.sentinels[data.Sentinel]
+	typ := errorRegistry.types[data.ErrType]
+	errorRegistry.RUnlock()
+	if sentinel != nil {
+		cachedErr.err = sentinel
+	} else if typ != nil {
+		codec := localCodec(typ.codec, data.Codec)
+		var err error
+		if codec == nil {
+			codec, err = getCodec(data.Codec)
+		}
+		if err == nil {
+			cachedErr.err, err = typ.decode(data.Data, codec)
+		}
+		if err != nil {
+			slogger.Error("gofnext: unmarshal error", "type", data.ErrType, "err", err.Error())
+		}
+	}
+	if cachedErr.err != nil && cachedErr.err.Error() == data.Msg {
+		// the original error is registered(not wrapped)
+		return cachedErr.err
+	}
+	return cachedErr
+}
@@ -0,0 +1,104 @@
+package gofnext
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type codecErr struct {
+	Code int
+	path string
+}
+
+func (e *codecErr) Error() string { return fmt.Sprintf("code %d: %s", e.Code, e.path) }
+
+func TestCachedError_Registry(t *testing.T) {
+	RegisterError(sql.ErrNoRows)
+	RegisterErrorType[*codecErr](nil)
+
+	errUnregistered := errors.New("unregistered")
+	errs := map[int]error{
+		1: sql.ErrNoRows,
+		2: fmt.Errorf("query user: %w", sql.ErrNoRows),
+		3: &codecErr{Code: 404, path: "/user"},
+		4: errUnregistered,
+	}
+	getUser := CacheFn1Err(func(id int) (int, error) {
+		return 0, errs[id]
+	}, &Config{
+		CacheMap: NewCacheRedis("cached-error").SetRedisClient(newFakeRedisClient()),
+		ErrTTL:   time.Hour,
+	})
+	for i := 0; i < 2; i++ {
+		// 1. sentinel error
+		_, err := getUser(1)
+		if err != sql.ErrNoRows {
+			t.Fatalf("unexpected err: %#v", err)
+		}
+
+		// 2. wrapped sentinel error
+		_, err = getUser(2)
+		if !errors.Is(err, sql.ErrNoRows) || err.Error() != errs[2].Error() {
+			t.Fatalf("unexpected err: %#v", err)
+		}
+
+		// 3. error type(private field is kept by CodecSerial)
+		_, err = getUser(3)
+		var target *codecErr
+		if !errors.As(err, &target) || target.Code != 404 || target.path != "/user" {
+			t.Fatalf("unexpected err: %#v", err)
+		}
+
+		// 4. unregistered error
+		_, err = getUser(4)
+		if err == nil || err.Error() != "unregistered" {
+			t.Fatalf("unexpected err: %#v", err)
+		}
+	}
+	var cachedErr *CachedError
+	_, err := getUser(4)
+	if !errors.As(err, &cachedErr) || cachedErr.Type != "*errors.errorString" {
+		t.Fatalf("unexpected err: %#v", err)
+	}
+}
+
+func TestCachedError_PlainMessage(t *testing.T) {
+	// error stored by old version is a plain message
+	err := unmarshalError([]byte("old error"))
+	var cachedErr *CachedError
+	if !errors.As(err, &cachedErr) || cachedErr.Msg != "old error" {
+		t.Fatalf("unexpected err: %#v", err)
+	}
+}
+
+func TestCachedError_DuplicateSentinel(t *testing.T) {
+	errNotFoundA := errors.New("duplicate: not found")
+	errNotFoundB := errors.New("duplicate: not found")
+	RegisterError(errNotFoundA)
+	RegisterError(errNotFoundA)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("duplicate sentinel should be rejected")
+			}
+		}()
+		RegisterError(errNotFoundB)
+	}()
+
+	// an explicit name tells them apart
+	RegisterErrorName("pkgb.ErrNotFound", errNotFoundB)
+	getUser := CacheFn1Err(func(id int) (int, error) {
+		return 0, errNotFoundB
+	}, &Config{
+		CacheMap: NewCacheRedis("duplicate-sentinel").SetRedisClient(newFakeRedisClient()),
+		ErrTTL:   time.Hour,
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := getUser(1); err != errNotFoundB {
+			t.Fatalf("unexpected err: %#v", err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package gofnext
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/ahuigo/gofnext/serial"
+)
+
+/*
+Codec marshals values for CacheMaps which need marshaling(e.g. redis).
+Its name is written into the cache envelope as content-type tag, so that values
+encoded by different codecs can be decoded correctly.
+*/
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// CodecMsgpack is the default codec
+	CodecMsgpack Codec = msgpackCodec{}
+	// CodecJSON can be read by non-Go services
+	CodecJSON Codec = jsonCodec{}
+	// CodecGob supports types which only support gob
+	CodecGob Codec = gobCodec{}
+	// CodecSerial includes unexported fields, shared pointers and interface types(see serial.Marshal)
+	CodecSerial Codec = serialCodec{}
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string                       { return "msgpack" }
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return marshalMsgpack(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return unmarshalMsgpack(data, v) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type serialCodec struct{}
+
+func (serialCodec) Name() string { return "serial" }
+func (serialCodec) Marshal(v any) ([]byte, error) {
+	// the decorator stores *V, and decodes into *V
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		return serial.Marshal(rv.Elem().Interface())
+	}
+	return serial.Marshal(v)
+}
+func (serialCodec) Unmarshal(data []byte, v any) error { return serial.Unmarshal(data, v) }
+
+var codecs sync.Map // name -> Codec
+
+func init() {
+	RegisterCodec(CodecMsgpack)
+	RegisterCodec(CodecJSON)
+	RegisterCodec(CodecGob)
+	RegisterCodec(CodecSerial)
+}
+
+// RegisterCodec registers custom codec, so that values tagged with its name can be decoded.
+func RegisterCodec(codec Codec) {
+	codecs.Store(codec.Name(), codec)
+}
+
+// getCodec returns the codec by name(empty name is msgpack for old cache)
+func getCodec(name string) (Codec, error) {
+	if name == "" {
+		return CodecMsgpack, nil
+	}
+	if codec, ok := codecs.Load(name); ok {
+		return codec.(Codec), nil
+	}
+	if codec, ok := getCompressCodec(name); ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("gofnext: unknown codec %q", name)
+}
+
+// encodedValue is a value marshaled by codec, it is returned by CacheMaps which need marshaling.
+type encodedValue struct {
+	data        []byte
+	codec       string
+	schema      string // schema version of the function(see Config.SchemaVersion)
+	fingerprint []byte // fingerprint of the full key(see Config.KeyFingerprint)
+	local       Codec  // codec configured for the CacheMap which loads the value
+	key         []byte // cache key bound to the data(see keyedCodec)
+}
+
+// keyedCodec binds the cache key to the encoded data(e.g. as additional data of AES-GCM),
+// so that data copied to another key can't be decoded.
+type keyedCodec interface {
+	marshalKey(v any, key []byte) ([]byte, error)
+	unmarshalKey(data []byte, v any, key []byte) error
+}
+
+func marshalWithKey(codec Codec, v any, key []byte) ([]byte, error) {
+	if kc, ok := codec.(keyedCodec); ok {
+		return kc.marshalKey(v, key)
+	}
+	return codec.Marshal(v)
+}
+
+func unmarshalWithKey(codec Codec, data []byte, v any, key []byte) error {
+	if kc, ok := codec.(keyedCodec); ok {
+		return kc.unmarshalKey(data, v, key)
+	}
+	return codec.Unmarshal(data, v)
+}
+
+// codecKey is the cache key bound to the encoded value, string keys(derived by the decorator) are used as is
+func codecKey(key any) []byte {
+	if s, ok := key.(string); ok {
+		return []byte(s)
+	}
+	data, _ := serial.BytesErr(key, false)
+	return data
+}
+
+// localCodec finds the codec named name in the codec chain configured for a CacheMap(e.g. the keys of NewCodecEncrypt)
+func localCodec(codec Codec, name string) Codec {
+	for codec != nil {
+		if codec.Name() == name {
+			return codec
+		}
+		switch c := codec.(type) {
+		case *compressCodec:
+			codec = c.codec
+		case *encryptCodec:
+			codec = c.codec
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// fingerprintedValue is stored by the decorator with Config.KeyFingerprint,
+// CacheMaps which need marshaling keep the fingerprint in the envelope.
+type fingerprintedValue struct {
+	value       any
+	fingerprint []byte
+}
+
+// decode unmarshals the value into v, with the codec configured for the CacheMap first
+func (e *encodedValue) decode(v any) error {
+	codec := localCodec(e.local, e.codec)
+	if codec == nil {
+		var err error
+		if codec, err = getCodec(e.codec); err != nil {
+			if strings.Contains(e.codec, encryptSuffix) {
+				// the keys are not configured for this CacheMap
+				return fmt.Errorf("%w: %v", errCacheMiss, err)
+			}
+			return err
+		}
+	}
+	return unmarshalWithKey(codec, e.data, v, e.key)
+}
+
+/*
+DecodeLoadResult decodes the value of r(e.g. loaded by CacheMapMultiLoader) into V, r.HasCache should be checked first.
+r.Err is the cached error of the function.
+
+	results, err := cacheMap.LoadMany([]any{1, 2})
+	if results[0].HasCache {
+		user, err := gofnext.DecodeLoadResult[*User](results[0])
+	}
+*/
+func DecodeLoadResult[V any](r CacheLoadResult) (v V, err error) {
+	value := r.Value
+	if fv, ok := value.(*fingerprintedValue); ok {
+		value = fv.value
+	}
+	switch data := value.(type) {
+	case *encodedValue:
+		err = data.decode(&v)
+	case *V:
+		v = *data
+	case nil:
+		err = errCacheMiss
+	default:
+		err = fmt.Errorf("gofnext: cannot decode %T into %T", value, v)
+	}
+	return v, err
+}
+
+// marshalValue marshals the value to be stored by a CacheMap which needs marshaling.
+// A value already marshaled by another CacheMap(e.g. an L2 value back-filled into L1) is stored as is.
+func marshalValue(key, v any, codec Codec, schema string) (*encodedValue, error) {
+	var fingerprint []byte
+	if fv, ok := v.(*fingerprintedValue); ok {
+		v, fingerprint = fv.value, fv.fingerprint
+	}
+	if encoded, ok := v.(*encodedValue); ok {
+		return encoded, nil
+	}
+	if codec == nil {
+		codec = CodecMsgpack
+	}
+	data, err := marshalWithKey(codec, v, codecKey(key))
+	return &encodedValue{data: data, codec: codec.Name(), schema: schema, fingerprint: fingerprint}, err
+}
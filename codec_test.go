@@ -0,0 +1,86 @@
+package gofnext
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type codecUser struct {
+	Name string
+	Age  int
+}
+
+func TestCodec_Config(t *testing.T) {
+	for _, codec := range []Codec{CodecMsgpack, CodecJSON, CodecGob, CodecSerial} {
+		client := newFakeRedisClient()
+		executeCount := 0
+		getUser := func(age int) (*codecUser, error) {
+			executeCount++
+			return &codecUser{Name: "Alex", Age: age}, nil
+		}
+		getUserWithCache := CacheFn1Err(getUser, &Config{
+			CacheMap: NewCacheRedis("codec").SetRedisClient(client),
+			Codec:    codec,
+		})
+		for i := 0; i < 3; i++ {
+			user, err := getUserWithCache(20)
+			if err != nil || user.Name != "Alex" || user.Age != 20 {
+				t.Fatalf("%s: unexpected user %v(%v)", codec.Name(), user, err)
+			}
+		}
+		AssertEqual(t, executeCount, 1)
+
+		// content-type tag is written into the envelope
+		cacheData := redisData{}
+		if err := unmarshalMsgpack(client.hashes["_gofnext:codec"][hashKey(20)], &cacheData); err != nil {
+			t.Fatal(err)
+		}
+		AssertEqual(t, cacheData.Codec, codec.Name())
+		if codec == CodecJSON {
+			// non-Go services can read the json value
+			m := map[string]any{}
+			if err := json.Unmarshal(cacheData.Data, &m); err != nil || m["Name"] != "Alex" {
+				t.Fatalf("unexpected json: %s(%v)", cacheData.Data, err)
+			}
+		}
+	}
+}
+
+func TestCodec_Rollout(t *testing.T) {
+	// values encoded by old codec can still be read after switching codec
+	client := newFakeRedisClient()
+	oldMap := NewCacheRedis("codec-rollout").SetRedisClient(client)
+	newMap := NewCacheRedis("codec-rollout").SetRedisClient(client).SetCodec(CodecJSON)
+
+	getUser := CacheFn1(func(age int) codecUser {
+		return codecUser{Name: "Alex", Age: age}
+	}, &Config{CacheMap: oldMap})
+	getUser(20)
+
+	executeCount := 0
+	getUserNew := CacheFn1(func(age int) codecUser {
+		executeCount++
+		return codecUser{}
+	}, &Config{CacheMap: newMap})
+	AssertEqual(t, getUserNew(20).Name, "Alex")
+	AssertEqual(t, executeCount, 0)
+}
+
+func TestCodec_Unknown(t *testing.T) {
+	var v int
+	encoded := &encodedValue{data: []byte("1"), codec: "unknown"}
+	if err := encoded.decode(&v); err == nil {
+		t.Fatal("should return error for unknown codec")
+	}
+
+	// custom codec
+	RegisterCodec(customCodec{})
+	encoded, _ = marshalValue("key", 2, customCodec{}, "")
+	if err := encoded.decode(&v); err != nil || v != 2 {
+		t.Fatalf("unexpected value %d(%v)", v, err)
+	}
+}
+
+type customCodec struct{ jsonCodec }
+
+func (customCodec) Name() string { return "custom" }
@@ -0,0 +1,115 @@
+package gofnext
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/ahuigo/gofnext/serial"
+)
+
+// Modes of KeyExplanation
+const (
+	KeyModeFunc   = "func"   // derived by Config.HashKeyFunc
+	KeyModeNative = "native" // arguments are compared by ==
+	KeyModeDump   = "dump"   // arguments are dumped(see serial.String)
+	KeyModeDigest = "digest" // arguments are digested(see Config.HashKeyDigest)
+)
+
+// KeyExplanation explains the cache key of a call(see CacheHandle.ExplainKey)
+type KeyExplanation struct {
+	// Key is the key passed to CacheMap
+	Key any
+	// Mode is how Key is derived: KeyModeFunc, KeyModeNative, KeyModeDump or KeyModeDigest
+	Mode string
+	// Dump is the canonical dump of arguments(empty if arguments can't be dumped)
+	Dump string
+	// BackendKey is the final key of CacheMaps implementing CacheMapBackendKey(e.g. redis field after SetMaxHashKeyLen)
+	BackendKey string
+}
+
+/*
+CacheHandle inspects the cached function it's bound to by Config.Handle:
+
+	handle := &gofnext.CacheHandle{}
+	getUserWithCache := gofnext.CacheFn1Err(getUser, &gofnext.Config{Handle: handle})
+	explanation, err := handle.ExplainKey(userId)
+*/
+type CacheHandle struct {
+	explainKey func(args ...any) (KeyExplanation, error)
+}
+
+// ExplainKey explains the cache key of calling the function with args
+func (h *CacheHandle) ExplainKey(args ...any) (KeyExplanation, error) {
+	if h.explainKey == nil {
+		return KeyExplanation{}, errors.New("gofnext: CacheHandle is not bound to a cached function")
+	}
+	return h.explainKey(args...)
+}
+
+func (c *cachedFn[K1, K2, K3, V]) explainKey(args ...any) (e KeyExplanation, err error) {
+	if len(args) != c.keyLen {
+		return e, fmt.Errorf("gofnext: expected %d arguments, got %d", c.keyLen, len(args))
+	}
+	var key1 K1
+	var key2 K2
+	var key3 K3
+	if c.keyLen > 0 {
+		if key1, err = argOf[K1](args, 0); err != nil {
+			return e, err
+		}
+	}
+	if c.keyLen > 1 {
+		if key2, err = argOf[K2](args, 1); err != nil {
+			return e, err
+		}
+	}
+	if c.keyLen > 2 {
+		if key3, err = argOf[K3](args, 2); err != nil {
+			return e, err
+		}
+	}
+
+	raw, needDumpKey := c.rawKey(key1, key2, key3)
+	data, dumpErr := serial.BytesErr(raw, c.hashKeyPointerAddr)
+	if dumpErr == nil {
+		e.Dump = string(data)
+	}
+	if c.hashKeyFunc != nil {
+		e.Mode = KeyModeFunc
+		if e.Key, err = c.hashKeyFuncWrap(key1, key2, key3); err != nil {
+			return e, err
+		}
+	} else {
+		if dumpErr != nil && needDumpKey {
+			return e, dumpErr
+		}
+		switch {
+		case !needDumpKey:
+			e.Mode, e.Key = KeyModeNative, raw
+		case c.hashKeyDigest != nil:
+			e.Mode = KeyModeDigest
+			if e.Key, err = c.dumpKey(raw); err != nil {
+				return e, err
+			}
+		default:
+			e.Mode, e.Key = KeyModeDump, e.Dump
+		}
+	}
+	if cacheMap, ok := c.cacheMap.(CacheMapBackendKey); ok {
+		e.BackendKey = cacheMap.BackendKey(e.Key)
+	}
+	return e, nil
+}
+
+// argOf converts args[i] to the parameter type K(nil is converted to zero value)
+func argOf[K any](args []any, i int) (k K, err error) {
+	if args[i] == nil {
+		return k, nil
+	}
+	k, ok := args[i].(K)
+	if !ok {
+		return k, fmt.Errorf("gofnext: argument %d should be %s, got %T", i, reflect.TypeOf((*K)(nil)).Elem(), args[i])
+	}
+	return k, nil
+}
@@ -0,0 +1,91 @@
+package gofnext
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func TestExplainKey(t *testing.T) {
+	type user struct{ ID int }
+	getScore := func(u *user, flag bool) (int, error) { return u.ID, nil }
+
+	handle := &CacheHandle{}
+	CacheFn2Err(getScore, &Config{Handle: handle})
+	e, err := handle.ExplainKey(&user{ID: 1}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Mode != KeyModeDump || e.Key != e.Dump || e.Dump != `v3:[2]interface {}([*github.com/ahuigo/gofnext.user(&user{ID:1}),bool(true)])` {
+		t.Errorf("unexpected explanation: %+v", e)
+	}
+
+	// native key
+	CacheFn1Err(func(id int) (int, error) { return id, nil }, &Config{Handle: handle})
+	if e, _ := handle.ExplainKey(1); e.Mode != KeyModeNative || e.Key != 1 || e.Dump != "v3:int(1)" {
+		t.Errorf("unexpected explanation: %+v", e)
+	}
+
+	// digest key
+	CacheFn2Err(getScore, &Config{Handle: handle, HashKeyDigest: sha256.New})
+	e, _ = handle.ExplainKey(&user{ID: 1}, true)
+	if digest := sha256.Sum256([]byte(e.Dump)); e.Mode != KeyModeDigest || e.Key != string(digest[:]) {
+		t.Errorf("unexpected explanation: %+v", e)
+	}
+
+	// custom hash key func
+	CacheFn2Err(getScore, &Config{Handle: handle, HashKeyFunc: func(args ...any) []byte { return []byte("k") }})
+	if e, _ := handle.ExplainKey(nil, false); e.Mode != KeyModeFunc || e.Key != "k" {
+		t.Errorf("unexpected explanation: %+v", e)
+	}
+
+	// context is not a part of key
+	CacheFn2Err(func(ctx context.Context, id int) (int, error) { return id, nil }, &Config{Handle: handle})
+	if e, _ := handle.ExplainKey(context.Background(), 2); e.Key != 2 {
+		t.Errorf("unexpected explanation: %+v", e)
+	}
+
+	if _, err := handle.ExplainKey(1); err == nil {
+		t.Error("wrong number of arguments should fail")
+	}
+	if _, err := handle.ExplainKey(nil, "2"); err == nil {
+		t.Error("wrong type of arguments should fail")
+	}
+	if _, err := (&CacheHandle{}).ExplainKey(); err == nil {
+		t.Error("unbound handle should fail")
+	}
+}
+
+func TestExplainKeyRedis(t *testing.T) {
+	handle := &CacheHandle{}
+	cacheMap := NewCacheRedis("explain").SetMaxHashKeyLen(32)
+	CacheFn1Err(func(name string) (int, error) { return len(name), nil }, &Config{CacheMap: cacheMap, Handle: handle})
+	e, err := handle.ExplainKey(strings.Repeat("a", 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// redisMap's HashKeyFunc is used
+	if e.Mode != KeyModeFunc || len(e.BackendKey) != 32 || e.BackendKey != cacheMap.strkey(e.Key) {
+		t.Errorf("unexpected explanation: %+v", e)
+	}
+	if e.Dump != `v3:string("`+strings.Repeat("a", 100)+`")` {
+		t.Errorf("unexpected dump: %s", e.Dump)
+	}
+
+	// the redis field is the key derived by HashKeyFunc
+	stub := newRedisStub(t)
+	cacheMap = NewCacheRedis("explain").SetRedisAddr(stub.Addr())
+	getSum := CacheFn2(func(id int, nums []int) int { return id + len(nums) }, &Config{CacheMap: cacheMap, Handle: handle})
+	getSum(1, []int{2})
+	e, err = handle.ExplainKey(1, []int{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Mode != KeyModeFunc || e.Dump != `v3:[2]interface {}([int(1),[]int([2])])` || e.BackendKey != hashKey(1, []int{2}) {
+		t.Errorf("unexpected explanation: %+v", e)
+	}
+	if _, hasCache, _, _ := cacheMap.Load(e.BackendKey); !hasCache {
+		t.Error("the backend key should be stored")
+	}
+}
@@ -0,0 +1,49 @@
+package gofnext
+
+import (
+	"testing"
+)
+
+func TestKeyFingerprint_Collision(t *testing.T) {
+	// all keys collide
+	collide := func(args ...any) []byte { return []byte("same") }
+	cacheMaps := map[string]func() CacheMap{
+		"mem":   func() CacheMap { return nil },
+		"redis": func() CacheMap { return NewCacheRedis("fingerprint").SetRedisClient(newFakeRedisClient()) },
+		"arena": func() CacheMap { return NewCacheArena(1 << 20) },
+	}
+	for name, newCacheMap := range cacheMaps {
+		stats := &CacheStats{}
+		executeCount := 0
+		double := CacheFn1(func(i int) int {
+			executeCount++
+			return i * 2
+		}, &Config{CacheMap: newCacheMap(), HashKeyFunc: collide, KeyFingerprint: true, Stats: stats})
+		AssertEqual(t, double(1), 2)
+		AssertEqual(t, double(1), 2)
+		AssertEqual(t, executeCount, 1)
+		// the entry of 1 is not returned for 2
+		AssertEqual(t, double(2), 4)
+		AssertEqual(t, executeCount, 2)
+		if stats.Collisions.Load() != 1 {
+			t.Errorf("%s: collisions should be 1, got %d", name, stats.Collisions.Load())
+		}
+	}
+}
+
+func TestKeyFingerprint_Legacy(t *testing.T) {
+	// entries stored without fingerprint are misses
+	client := newFakeRedisClient()
+	getName := CacheFn1(func(id int) string {
+		return "old"
+	}, &Config{CacheMap: NewCacheRedis("fingerprint-legacy").SetRedisClient(client)})
+	getName(1)
+
+	stats := &CacheStats{}
+	getName = CacheFn1(func(id int) string {
+		return "new"
+	}, &Config{CacheMap: NewCacheRedis("fingerprint-legacy").SetRedisClient(client), KeyFingerprint: true, Stats: stats})
+	AssertEqual(t, getName(1), "new")
+	AssertEqual(t, getName(1), "new")
+	AssertEqual(t, stats.Collisions.Load(), int64(0))
+}
@@ -0,0 +1,103 @@
+package gofnext
+
+import (
+	"testing"
+)
+
+func TestSchemaVersion_Mismatch(t *testing.T) {
+	client := newFakeRedisClient()
+	type UserV1 struct{ Name string }
+	type UserV2 struct{ FullName string }
+
+	getUserV1 := CacheFn1(func(id int) UserV1 {
+		return UserV1{Name: "Alex"}
+	}, &Config{CacheMap: NewCacheRedis("schema").SetRedisClient(client), SchemaVersion: "v1"})
+	getUserV1(1)
+
+	// v2 is deployed: old entries are misses(recompute and overwrite)
+	stats := &CacheStats{}
+	executeCount := 0
+	getUserV2 := CacheFn1(func(id int) UserV2 {
+		executeCount++
+		return UserV2{FullName: "Alex Lee"}
+	}, &Config{CacheMap: NewCacheRedis("schema").SetRedisClient(client), SchemaVersion: "v2", Stats: stats})
+	AssertEqual(t, getUserV2(1).FullName, "Alex Lee")
+	AssertEqual(t, getUserV2(1).FullName, "Alex Lee")
+	AssertEqual(t, executeCount, 1)
+	AssertEqual(t, stats.SchemaMismatches.Load(), int64(1))
+}
+
+func TestSchemaVersion_DecodeFailure(t *testing.T) {
+	client := newFakeRedisClient()
+	getName := CacheFn1(func(id int) string {
+		return "Alex"
+	}, &Config{CacheMap: NewCacheRedis("decode-failure").SetRedisClient(client)})
+	getName(1)
+
+	// the struct is changed without bumping the schema version
+	stats := &CacheStats{}
+	executeCount := 0
+	getAge := CacheFn1Err(func(id int) (map[string]int, error) {
+		executeCount++
+		return map[string]int{"age": 20}, nil
+	}, &Config{CacheMap: NewCacheRedis("decode-failure").SetRedisClient(client), Stats: stats})
+	for i := 0; i < 2; i++ {
+		age, err := getAge(1)
+		if err != nil || age["age"] != 20 {
+			t.Fatalf("decode failure should not be surfaced: %v(%v)", age, err)
+		}
+	}
+	AssertEqual(t, executeCount, 1)
+	AssertEqual(t, stats.DecodeFailures.Load(), int64(1))
+}
+
+func TestSchemaVersion_Arena(t *testing.T) {
+	m := NewCacheArena(1 << 20)
+	m.SetSchemaVersion("v1")
+	v := 1
+	m.Store("k", &v, nil)
+	value, _, _, _ := m.Load("k")
+	AssertEqual(t, value.(*encodedValue).schema, "v1")
+}
+
+// bytesCacheMap stores msgpack bytes without asking the decorator to marshal values
+type bytesCacheMap struct {
+	*memCacheMap
+}
+
+func (m bytesCacheMap) Store(key, value any, err error) {
+	data, _ := marshalMsgpack(value)
+	m.memCacheMap.Store(key, data, err)
+}
+
+func TestSchemaVersion_RawBytes(t *testing.T) {
+	// bytes returned by CacheMaps are decoded even if NeedMarshal is false
+	executeCount := 0
+	getName := CacheFn1(func(id int) string {
+		executeCount++
+		return "Alex"
+	}, &Config{CacheMap: bytesCacheMap{newCacheMapMem(0)}})
+	AssertEqual(t, getName(1), "Alex")
+	AssertEqual(t, getName(1), "Alex")
+	AssertEqual(t, executeCount, 1)
+}
+
+func TestSchemaVersion_Setter(t *testing.T) {
+	// the version set on the CacheMap directly is expected by the decorator
+	for _, cacheMap := range []CacheMap{
+		NewCacheRedis("schema-setter").SetRedisClient(newFakeRedisClient()).SetSchemaVersion("v2"),
+		NewCacheArena(1 << 20).SetSchemaVersion("v2"),
+	} {
+		stats := &CacheStats{}
+		executeCount := 0
+		getName := CacheFn1(func(id int) string {
+			executeCount++
+			return "Alex"
+		}, &Config{CacheMap: cacheMap, Stats: stats})
+		for i := 0; i < 3; i++ {
+			AssertEqual(t, getName(1), "Alex")
+		}
+		AssertEqual(t, executeCount, 1)
+		AssertEqual(t, stats.SchemaMismatches.Load(), int64(0))
+	}
+}
@@ -1,9 +1,14 @@
 package gofnext
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"hash"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ahuigo/gofnext/serial"
@@ -24,21 +29,67 @@ type Config struct {
 	NeedDumpKey        bool
 	HashKeyPointerAddr bool
 	HashKeyFunc        func(args ...any) []byte
+	/* HashKeyDigest replaces dumped keys with their digests(e.g. sha256.New, or fnv.New128a for speed),
+	it saves CPU and key memory for big arguments(e.g. slices of thousands of structs).
+	Keys derived by HashKeyFunc(or by the CacheMap, e.g. redis) are digested as well.
+	*/
+	HashKeyDigest func() hash.Hash
 	/* ReuseTTl controls how to handle expired cache:
 	if ReuseTTl>0: When cache is expired but within ReuseTTl duration, return the expired cache and update it asynchronously
 	if ReuseTTl=0: When cache is expired, wait for the cache to be updated
 	*/
 	ReuseTTL time.Duration
+	/* Codec marshals values for CacheMaps which need marshaling(e.g. redis):
+	CodecMsgpack(default), CodecJSON, CodecGob or custom Codec
+	*/
+	Codec Codec
+	/* SchemaVersion is written into the envelope of CacheMaps which need marshaling.
+	Change it when V's structure is changed: cached values with another version are treated as cache miss(recompute and overwrite).
+	If it's empty, the version set by the CacheMap's SetSchemaVersion is used.
+	*/
+	SchemaVersion string
+	// Stats counts cache events of the function(optional)
+	Stats *CacheStats
+	/* OnKeyError is called when the cache key can't be derived from arguments(e.g. unsafe.Pointer),
+	then the function is called directly(bypass the cache). If it's nil, the decorator panics.
+	*/
+	OnKeyError func(err error)
+	// Handle is bound to the cached function to inspect it(optional), e.g. Handle.ExplainKey(args...)
+	Handle *CacheHandle
+	/* KeyFingerprint stores the SHA-256 fingerprint of the full canonical key with each entry, and verifies it on Load.
+	It detects collisions of hashed keys(e.g. redisMap.SetMaxHashKeyLen, HashKeyFunc): a mismatch is a cache miss
+	counted by CacheStats.Collisions.
+	Don't use it with a HashKeyFunc which maps different arguments to one key on purpose(e.g. ignores some of them):
+	those calls have different fingerprints, so they overwrite each other's entry and always miss.
+	*/
+	KeyFingerprint bool
+}
+
+// CacheStats counts cache events of a function(see Config.Stats)
+type CacheStats struct {
+	// SchemaMismatches counts cached values with another schema version(treated as cache miss)
+	SchemaMismatches atomic.Int64
+	// DecodeFailures counts cached values which cannot be decoded(treated as cache miss)
+	DecodeFailures atomic.Int64
+	// KeyErrors counts calls whose cache key can't be derived(see Config.OnKeyError)
+	KeyErrors atomic.Int64
+	// Collisions counts cached values whose key fingerprint mismatches(see Config.KeyFingerprint)
+	Collisions atomic.Int64
 }
 
 type cachedFn[K1, K2, K3 any, V any] struct {
 	needDumpKey        bool
 	hashKeyPointerAddr bool
 	hashKeyFunc        func(args ...any) []byte
+	hashKeyDigest      func() hash.Hash
 	cacheMap           CacheMap
 	pkeyLockMap        sync.Map
 	keyLen             int
 	getFunc            func(K1, K2, K3) (V, error)
+	schemaVersion      string
+	stats              *CacheStats
+	onKeyError         func(err error)
+	keyFingerprint     bool
 }
 
 func (c *cachedFn[K1, K2, K3, V]) setConfigs(configs ...*Config) *cachedFn[K1, K2, K3, V] {
@@ -61,6 +112,17 @@ func (c *cachedFn[K1, K2, K3, V]) setConfig(config *Config) *cachedFn[K1, K2, K3
 	// init value
 	c.hashKeyPointerAddr = config.HashKeyPointerAddr
 	c.needDumpKey = config.NeedDumpKey
+	c.hashKeyDigest = config.HashKeyDigest
+	c.onKeyError = config.OnKeyError
+	if config.KeyFingerprint && config.CacheMap.NeedMarshal() {
+		if _, ok := config.CacheMap.(CacheMapCodec); !ok {
+			panic("KeyFingerprint is not supported by the CacheMap")
+		}
+	}
+	c.keyFingerprint = config.KeyFingerprint
+	if config.Handle != nil {
+		config.Handle.explainKey = c.explainKey
+	}
 	c.cacheMap = config.CacheMap
 	if config.ErrTTL < -1 {
 		panic("ErrTTL should not be less than -1")
@@ -70,6 +132,26 @@ func (c *cachedFn[K1, K2, K3, V]) setConfig(config *Config) *cachedFn[K1, K2, K3
 	}
 	c.cacheMap.SetErrTTL(config.ErrTTL)
 	c.cacheMap.SetReuseTTL(config.ReuseTTL)
+	if config.Codec != nil {
+		if cacheMap, ok := c.cacheMap.(CacheMapCodec); ok {
+			cacheMap.SetCodec(config.Codec)
+		}
+	}
+	if config.HashKeyDigest != nil {
+		if cacheMap, ok := c.cacheMap.(CacheMapKeyDigest); ok {
+			cacheMap.SetHashKeyDigest(config.HashKeyDigest)
+		}
+	}
+	c.schemaVersion = config.SchemaVersion
+	if config.SchemaVersion != "" {
+		if cacheMap, ok := c.cacheMap.(CacheMapSchema); ok {
+			cacheMap.SetSchemaVersion(config.SchemaVersion)
+		}
+	}
+	c.stats = config.Stats
+	if c.stats == nil {
+		c.stats = &CacheStats{}
+	}
 	if config.TTL > 0 {
 		c.cacheMap.SetTTL(config.TTL)
 	}
@@ -254,6 +336,21 @@ func (c *cachedFn[K1, K2, K3, V]) invoke3(key1 K1, key2 K2, key3 K3) (retv V) {
 
 var _isHashKey map[any]int
 
+var customKeyTypes sync.Map // reflect.Type -> bool
+
+// hasCustomKey reports whether the key of typ is derived by tags, CacheKey or normalizers
+func hasCustomKey(typ reflect.Type) bool {
+	if typ == nil {
+		return false
+	}
+	if has, ok := customKeyTypes.Load(typ); ok {
+		return has.(bool)
+	}
+	has := serial.HasKeyTag(typ) || serial.HasCacheKeyer(typ) || serial.HasNormalizer(typ)
+	customKeyTypes.Store(typ, has)
+	return has
+}
+
 func isHashableKey(key any, cmpPtr bool) (canHash bool) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -261,30 +358,55 @@ func isHashableKey(key any, cmpPtr bool) (canHash bool) {
 		}
 	}()
 	_ = _isHashKey[key]
+	if hasCustomKey(reflect.TypeOf(key)) {
+		// fields excluded by tags(or replaced by CacheKey and normalizers) should not be compared
+		return false
+	}
 	if cmpPtr {
 		return true
 	}
 	return reflect.ValueOf(key).Kind() != reflect.Pointer
 }
 
-func (c *cachedFn[K1, K2, K3, V]) hashKeyFuncWrap(key1 K1, key2 K2, key3 K3) (pkey any) {
+func (c *cachedFn[K1, K2, K3, V]) hashKeyFuncWrap(key1 K1, key2 K2, key3 K3) (pkey any, err error) {
 	// outer hash key func
 	if c.hashKeyFunc != nil {
+		// a key which can't be dumped(e.g. by dumpHashKey of redis-backed maps) panics with the error
+		defer func() {
+			if r := recover(); r != nil {
+				if e, ok := r.(error); ok {
+					err = fmt.Errorf("gofnext: hash key: %w", e)
+				} else {
+					err = fmt.Errorf("gofnext: hash key: %v", r)
+				}
+			}
+		}()
+		var key []byte
 		if c.keyLen == 3 {
-			pkey = string(c.hashKeyFunc(key1, key2, key3))
+			key = c.hashKeyFunc(key1, key2, key3)
 		} else if c.keyLen == 2 {
-			pkey = string(c.hashKeyFunc(key1, key2))
+			key = c.hashKeyFunc(key1, key2)
 		} else if c.keyLen == 1 {
-			pkey = string(c.hashKeyFunc(key1))
+			key = c.hashKeyFunc(key1)
 		} else {
-			pkey = 0
+			return 0, nil
 		}
-		return pkey
+		// long keys derived by the map(e.g. redis) are digested as well
+		return string(digestKey(c.hashKeyDigest, key)), nil
 	}
 
 	// inner hash key func
+	pkey, needDumpKey := c.rawKey(key1, key2, key3)
+	if needDumpKey {
+		return c.dumpKey(pkey)
+	}
+	return pkey, nil
+}
+
+// rawKey returns the arguments(without context) as key, and whether the key should be dumped
+func (c *cachedFn[K1, K2, K3, V]) rawKey(key1 K1, key2 K2, key3 K3) (pkey any, needDumpKey bool) {
 	needHashPtrAddr := c.hashKeyPointerAddr
-	needDumpKey := c.needDumpKey
+	needDumpKey = c.needDumpKey
 	if c.keyLen == 3 {
 		if _, hasCtx := any(key1).(context.Context); hasCtx {
 			pkey = [2]any{key2, key3}
@@ -321,16 +443,38 @@ func (c *cachedFn[K1, K2, K3, V]) hashKeyFuncWrap(key1 K1, key2 K2, key3 K3) (pk
 	} else {
 		pkey = 0
 	}
-	if needDumpKey {
-		pkey = serial.String(pkey, needHashPtrAddr)
+	return pkey, needDumpKey
+}
+
+// dumpKey dumps(or digests) the raw key into string
+func (c *cachedFn[K1, K2, K3, V]) dumpKey(raw any) (pkey any, err error) {
+	if c.hashKeyDigest != nil {
+		return digestRawKey(c.hashKeyDigest, raw, c.hashKeyPointerAddr)
+	}
+	data, err := serial.BytesErr(raw, c.hashKeyPointerAddr)
+	if err != nil {
+		return nil, err
 	}
-	return pkey
+	return string(data), nil
 }
 
 // Invoke cached function with 2 parameter
 func (c *cachedFn[K1, K2, K3, V]) invoke3err(key1 K1, key2 K2, key3 K3) (retv V, err error) {
 	// 1. generate pkey
-	var pkey any = c.hashKeyFuncWrap(key1, key2, key3)
+	pkey, err := c.hashKeyFuncWrap(key1, key2, key3)
+	var fingerprint []byte
+	if err == nil && c.keyFingerprint {
+		fingerprint, err = c.fingerprint(key1, key2, key3)
+	}
+	if err != nil {
+		c.stats.KeyErrors.Add(1)
+		if c.onKeyError == nil {
+			panic(err)
+		}
+		// bypass the cache
+		c.onKeyError(err)
+		return c.getFunc(key1, key2, key3)
+	}
 
 	// 2. require lock for each pkey(go routine safe)
 	var tmpOnce sync.RWMutex
@@ -347,16 +491,13 @@ checkCache:
 	pkeyLock.RLock()
 	value, hasCache, alive, err := c.cacheMap.Load(pkey)
 	pkeyLock.RUnlock()
-
-	// 3.1 check if marshal needed
-	if hasCache && c.cacheMap.NeedMarshal() {
-		// err2 := json.Unmarshal(value.([]byte), &retv)
-		err2 := unmarshalMsgpack(value.([]byte), &retv)
-		if err == nil {
-			err = err2
+	if hasCache {
+		// a value which cannot be decoded(e.g. schema mismatch) is a cache miss
+		retv, err = c.decodeValue(value, err, fingerprint)
+		if errors.Is(err, errCacheMiss) {
+			hasCache = false
+			err = nil
 		}
-		value = &retv
-		// return retv, err
 	}
 
 	// 4. Execute getFunc(only once)
@@ -382,9 +523,24 @@ checkCache:
 		}
 		defer pkeyLock.Unlock()
 
-		// 4.2 check cache again
+		// 4.2 acquire cross-process lock(e.g. redis lease), so that only one process executes the getFunc
+		if locker, ok := c.cacheMap.(CacheMapLocker); ok {
+			unlock, acquired := locker.Lock(pkey)
+			defer unlock()
+			if !acquired {
+				// another process has stored the fresh value
+				value, hasCache, _, err = c.cacheMap.Load(pkey)
+				if hasCache {
+					if retv, err = c.decodeValue(value, err, fingerprint); !errors.Is(err, errCacheMiss) {
+						return retv, err
+					}
+				}
+			}
+		}
+
+		// 4.3 execute getFunc
 		val, err2 := c.getFunc(key1, key2, key3)
-		c.cacheMap.Store(pkey, &val, err2)
+		c.storeValue(pkey, fingerprint, &val, err2)
 		return val, err2
 	} else if hasCache && !alive {
 		// If the cache is not alive,  it will return the expired cache (and update the cache asynchronously)
@@ -397,11 +553,99 @@ checkCache:
 				return
 			}
 			defer pkeyLock.Unlock()
+			if locker, ok := c.cacheMap.(CacheMapLocker); ok {
+				unlock, acquired := locker.Lock(pkey)
+				defer unlock()
+				if !acquired {
+					return
+				}
+			}
 			// 5.2 check cache again
 			val, err2 := c.getFunc(key1, key2, key3)
-			c.cacheMap.Store(pkey, &val, err2)
+			c.storeValue(pkey, fingerprint, &val, err2)
 		}()
 
 	}
-	return *(value).(*V), err
+	return retv, err
+}
+
+// decodeValue converts cached value to V(unmarshal it if CacheMap needs marshaling)
+// expectedSchema returns Config.SchemaVersion, or the version set on the CacheMap directly
+func (c *cachedFn[K1, K2, K3, V]) expectedSchema() string {
+	if c.schemaVersion == "" {
+		if cacheMap, ok := c.cacheMap.(cacheMapSchemaVersion); ok {
+			return cacheMap.SchemaVersion()
+		}
+	}
+	return c.schemaVersion
+}
+
+func (c *cachedFn[K1, K2, K3, V]) decodeValue(value any, err error, fingerprint []byte) (retv V, _ error) {
+	if fv, ok := value.(*fingerprintedValue); ok {
+		if !c.verifyFingerprint(fv.fingerprint, fingerprint) {
+			return retv, errCacheMiss
+		}
+		value = fv.value
+	}
+	// a tiered CacheMap may return either marshaled value or the original *V
+	var err2 error
+	switch data := value.(type) {
+	case *encodedValue:
+		if data.schema != c.expectedSchema() {
+			c.stats.SchemaMismatches.Add(1)
+			return retv, errCacheMiss
+		}
+		if !c.verifyFingerprint(data.fingerprint, fingerprint) {
+			return retv, errCacheMiss
+		}
+		err2 = data.decode(&retv)
+	case []byte:
+		// custom CacheMaps return msgpack bytes
+		// err2 := json.Unmarshal(value.([]byte), &retv)
+		err2 = unmarshalMsgpack(data, &retv)
+	default:
+		return *(value).(*V), err
+	}
+	if err2 != nil {
+		// e.g. V's structure is changed: recompute and overwrite the cache
+		c.stats.DecodeFailures.Add(1)
+		slogger.Warn("gofnext: decode cache", "err", err2.Error())
+		return retv, fmt.Errorf("%w: %v", errCacheMiss, err2)
+	}
+	return retv, err
+}
+
+// fingerprint digests the full canonical key of the arguments(see Config.KeyFingerprint)
+func (c *cachedFn[K1, K2, K3, V]) fingerprint(key1 K1, key2 K2, key3 K3) ([]byte, error) {
+	raw, _ := c.rawKey(key1, key2, key3)
+	digest, err := serial.HashErr(raw, &serial.HashOptions{HashPtrAddr: c.hashKeyPointerAddr})
+	if err != nil {
+		return nil, err
+	}
+	return digest[:], nil
+}
+
+// verifyFingerprint reports whether the stored fingerprint matches the key's fingerprint
+func (c *cachedFn[K1, K2, K3, V]) verifyFingerprint(stored, fingerprint []byte) bool {
+	if fingerprint == nil {
+		return true
+	}
+	if len(stored) == 0 {
+		// stored before KeyFingerprint is enabled
+		return false
+	}
+	if !bytes.Equal(stored, fingerprint) {
+		c.stats.Collisions.Add(1)
+		slogger.Warn("gofnext: key collision", "stored", fmt.Sprintf("%x", stored), "key", fmt.Sprintf("%x", fingerprint))
+		return false
+	}
+	return true
+}
+
+// storeValue stores the value with the key's fingerprint(see Config.KeyFingerprint)
+func (c *cachedFn[K1, K2, K3, V]) storeValue(pkey any, fingerprint []byte, value any, err error) {
+	if fingerprint != nil {
+		value = &fingerprintedValue{value: value, fingerprint: fingerprint}
+	}
+	c.cacheMap.Store(pkey, value, err)
 }
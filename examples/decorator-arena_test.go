@@ -0,0 +1,41 @@
+package examples
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahuigo/gofnext"
+)
+
+func TestCacheFuncWithArena(t *testing.T) {
+	type UserInfo struct {
+		Name string
+		Age  int
+	}
+	// Original function
+	executeCount := 0
+	getUser := func(id int) (*UserInfo, error) {
+		executeCount++
+		return &UserInfo{Name: "Alex", Age: 20 + id}, nil
+	}
+
+	// Cacheable Function
+	getUserWithCache := gofnext.CacheFn1Err(getUser, &gofnext.Config{
+		TTL:      time.Hour,
+		CacheMap: gofnext.NewCacheArena(64 << 20),
+	})
+
+	// Execute the function multi times in parallel.
+	parallelCall(func() {
+		user, err := getUserWithCache(1)
+		if err != nil || user.Age != 21 {
+			t.Errorf("age should be 21, but get %v(%v)", user, err)
+		}
+		getUserWithCache(2)
+		getUserWithCache(2)
+	}, 10)
+
+	if executeCount != 2 {
+		t.Errorf("executeCount should be 2, but get %d", executeCount)
+	}
+}
@@ -2,6 +2,7 @@ package examples
 
 import (
 	"fmt"
+	"log"
 	"testing"
 	"time"
 
@@ -51,3 +52,99 @@ func TestCacheFuncKeyCustom(t *testing.T) {
 		t.Errorf("executeCount should be 3, but get %d", executeCount)
 	}
 }
+
+func TestCacheFuncKeyTag(t *testing.T) {
+	type Request struct {
+		UserID  int
+		TraceID string      `gofnext:"-"`
+		Logger  *log.Logger `gofnext:"-"`
+		Created time.Time   `gofnext:"-"`
+	}
+	// Original function
+	executeCount := 0
+	getUserScore := func(req Request) (int, error) {
+		executeCount++
+		return 98 + req.UserID, nil
+	}
+
+	// Cacheable Function: TraceID, Logger and Created are excluded from the cache key
+	getUserScoreWithCache := gofnext.CacheFn1Err(getUserScore)
+
+	getUserScoreWithCache(Request{UserID: 1, TraceID: "a", Created: time.Now()})
+	getUserScoreWithCache(Request{UserID: 1, TraceID: "b", Logger: log.Default(), Created: time.Now()})
+	if score, _ := getUserScoreWithCache(Request{UserID: 2, TraceID: "c"}); score != 100 {
+		t.Errorf("score should be 100, but get %d", score)
+	}
+	if executeCount != 2 {
+		t.Errorf("executeCount should be 2, but get %d", executeCount)
+	}
+}
+
+type keyerUser struct {
+	id   int
+	Name string
+}
+
+// CacheKey implements gofnext.CacheKeyer
+func (u *keyerUser) CacheKey() string {
+	return fmt.Sprintf("user:%d", u.id)
+}
+
+func TestCacheFuncKeyer(t *testing.T) {
+	// Original function
+	executeCount := 0
+	getUserScore := func(user *keyerUser, flag bool) (int, error) {
+		executeCount++
+		return 98 + user.id, nil
+	}
+
+	// Cacheable Function: user's key is user.CacheKey()
+	getUserScoreWithCache := gofnext.CacheFn2Err(getUserScore, &gofnext.Config{
+		HashKeyPointerAddr: true,
+	})
+
+	getUserScoreWithCache(&keyerUser{id: 1, Name: "a"}, true)
+	getUserScoreWithCache(&keyerUser{id: 1, Name: "b"}, true)
+	if score, _ := getUserScoreWithCache(&keyerUser{id: 2}, true); score != 100 {
+		t.Errorf("score should be 100, but get %d", score)
+	}
+	if executeCount != 2 {
+		t.Errorf("executeCount should be 2, but get %d", executeCount)
+	}
+}
+
+type keyerSession struct {
+	UserID int
+	Token  string
+}
+
+// CacheKey implements gofnext.CacheKeyer
+func (s keyerSession) CacheKey() string {
+	return fmt.Sprintf("session:%d", s.UserID)
+}
+
+type keyerRequest struct {
+	Auth *struct{ Session keyerSession }
+}
+
+func TestCacheFuncKeyerNested(t *testing.T) {
+	// CacheKeyer held by pointer: requests are dumped rather than compared by pointer address
+	executeCount := 0
+	getUserScore := func(req keyerRequest) int {
+		executeCount++
+		return 98 + req.Auth.Session.UserID
+	}
+	getUserScoreWithCache := gofnext.CacheFn1(getUserScore)
+
+	newRequest := func(userID int, token string) keyerRequest {
+		return keyerRequest{Auth: &struct{ Session keyerSession }{keyerSession{userID, token}}}
+	}
+	getUserScoreWithCache(newRequest(1, "a"))
+	getUserScoreWithCache(newRequest(1, "b"))
+	if score := getUserScoreWithCache(newRequest(2, "a")); score != 100 {
+		t.Errorf("score should be 100, but get %d", score)
+	}
+	if executeCount != 2 {
+		t.Errorf("executeCount should be 2, but get %d", executeCount)
+	}
+}
@@ -0,0 +1,45 @@
+package examples
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/ahuigo/gofnext"
+)
+
+func TestCacheFuncKeyDigest(t *testing.T) {
+	type Item struct {
+		ID   int
+		Name string
+	}
+	// Original function
+	executeCount := 0
+	sumItems := func(items []Item) (int, error) {
+		executeCount++
+		sum := 0
+		for _, item := range items {
+			sum += item.ID
+		}
+		return sum, nil
+	}
+
+	// Cacheable Function: the key is the sha256 digest of the big slice
+	sumItemsWithCache := gofnext.CacheFn1Err(sumItems, &gofnext.Config{
+		HashKeyDigest: sha256.New,
+	})
+
+	items := make([]Item, 5000)
+	for i := range items {
+		items[i] = Item{ID: i, Name: "item"}
+	}
+	for i := 0; i < 3; i++ {
+		if sum, _ := sumItemsWithCache(items); sum != 12497500 {
+			t.Errorf("sum should be 12497500, but get %d", sum)
+		}
+	}
+	items[0].Name = "changed"
+	sumItemsWithCache(items)
+	if executeCount != 2 {
+		t.Errorf("executeCount should be 2, but get %d", executeCount)
+	}
+}
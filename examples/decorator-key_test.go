@@ -1,11 +1,14 @@
 package examples
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/ahuigo/gofnext"
+	"github.com/ahuigo/gofnext/serial"
 )
 
 func TestCacheFuncKeyStruct(t *testing.T) {
@@ -240,3 +243,57 @@ func TestCacheFuncKeyInnerPointer(t *testing.T) {
 		t.Errorf("executeCount should be 3, but get %d", executeCount)
 	}
 }
+
+func TestCacheFuncKeyError(t *testing.T) {
+	// unsafe.Pointer can't be dumped, so the cache is bypassed(including the keys dumped by redis-backed maps)
+	for _, cacheMap := range []gofnext.CacheMap{nil, gofnext.NewCacheRedis("key-error")} {
+		// Original function
+		executeCount := 0
+		countPtrs := func(ptrs []unsafe.Pointer) (int, error) {
+			executeCount++
+			return len(ptrs), nil
+		}
+
+		// Cacheable Function
+		var keyErr error
+		stats := &gofnext.CacheStats{}
+		countPtrsWithCache := gofnext.CacheFn1Err(countPtrs, &gofnext.Config{
+			CacheMap:   cacheMap,
+			Stats:      stats,
+			OnKeyError: func(err error) { keyErr = err },
+		})
+
+		ptrs := []unsafe.Pointer{nil}
+		for i := 0; i < 2; i++ {
+			if n, _ := countPtrsWithCache(ptrs); n != 1 {
+				t.Errorf("n should be 1, but get %d", n)
+			}
+		}
+		if executeCount != 2 || stats.KeyErrors.Load() != 2 {
+			t.Errorf("cache should be bypassed, executeCount=%d, keyErrors=%d", executeCount, stats.KeyErrors.Load())
+		}
+		if !errors.Is(keyErr, serial.ErrUnsupportedKind) {
+			t.Errorf("unexpected key error: %v", keyErr)
+		}
+	}
+}
+
+func TestCacheFuncKeyTime(t *testing.T) {
+	// Original function
+	executeCount := 0
+	getDay := func(at time.Time) (int, error) {
+		executeCount++
+		return at.UTC().Day(), nil
+	}
+
+	// Cacheable Function: equal instants in different locations share the cache
+	getDayWithCache := gofnext.CacheFn1Err(getDay)
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	getDayWithCache(at)
+	getDayWithCache(at.In(time.FixedZone("UTC+8", 8*3600)))
+	getDayWithCache(at.Local())
+	if executeCount != 1 {
+		t.Errorf("executeCount should be 1, but get %d", executeCount)
+	}
+}
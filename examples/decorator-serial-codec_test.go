@@ -0,0 +1,55 @@
+package examples
+
+import (
+	"testing"
+
+	"github.com/ahuigo/gofnext"
+)
+
+func TestSerialCodecPrivateField(t *testing.T) {
+	count := 0
+	type Stu struct {
+		Age     int
+		private int
+	}
+	type M = map[string]any
+	type Data struct {
+		M M
+	}
+
+	getNum := func(i float64) *Data {
+		count++
+		m := M{
+			"i8":  int8(i),
+			"u8":  uint8(i),
+			"f32": float32(2),
+			"stu": Stu{
+				Age:     18,
+				private: 1,
+			},
+		}
+		return &Data{M: m}
+	}
+
+	// Cacheable Function(arena marshals values like redis)
+	getNumWithCache := gofnext.CacheFn1(
+		getNum,
+		&gofnext.Config{
+			CacheMap: gofnext.NewCacheArena(1 << 20),
+			Codec:    gofnext.CodecSerial,
+		},
+	)
+
+	getNumWithCache(98)
+	data := getNumWithCache(98)
+	m := data.M
+	if m["u8"].(uint8) != 98 || m["i8"].(int8) != 98 || m["f32"].(float32) != 2 {
+		t.Errorf("unexpected values: %v", m)
+	}
+	if stu := m["stu"].(Stu); stu.Age != 18 || stu.private != 1 {
+		t.Errorf("unexpected stu: %#v", stu)
+	}
+	if count != 1 {
+		t.Errorf("count should be 1, but get %d", count)
+	}
+}
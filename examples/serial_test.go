@@ -20,7 +20,7 @@ func TestDumpCycleSlice(t *testing.T) {
 	}
 	person.children[0].children = []Person{person}
 	result := serial.String(&person, false)
-	assertContains(t, result, `<cycle slice>`)
+	assertContains(t, result, `children:<cycle slice ^2>`)
 }
 
 func TestDumpCycleMap(t *testing.T) {
@@ -29,7 +29,7 @@ func TestDumpCycleMap(t *testing.T) {
 		"k2": m,
 	}
 	result := serial.String(&m, false)
-	assertContains(t, result, `<cycle map>`)
+	assertContains(t, result, `"k2":map[string]interface {}(<cycle map ^2>)`)
 	assertContains(t, result, `&{"k1":`)
 	t.Log(result)
 }
@@ -37,7 +37,7 @@ func TestDumpCycleMap(t *testing.T) {
 func TestDumpStringPtr(t *testing.T) {
 	// Test case 1: Integer
 	num := 42
-	expectedNum := "*0x"
+	expectedNum := "v3:*int(*0x"
 	if result := serial.String(&num, true); !strings.HasPrefix(result, expectedNum) {
 		t.Errorf("Expected prefix %s, but got %s", expectedNum, result)
 	}
@@ -45,14 +45,14 @@ func TestDumpStringPtr(t *testing.T) {
 func TestDumpString(t *testing.T) {
 	// Test case 1: Integer
 	num := 42
-	expectedNum := "42"
+	expectedNum := "v3:int(42)"
 	if result := serial.String(num, false); result != expectedNum {
 		t.Errorf("Expected %s, but got %s", expectedNum, result)
 	}
 
 	// Test case 2: String
 	str := "Hello, World!"
-	expectedStr := `"Hello, World!"`
+	expectedStr := `v3:string("Hello, World!")`
 	if result := serial.String(str, false); result != expectedStr {
 		t.Errorf("Expected %s, but got %s", expectedStr, result)
 	}
@@ -60,35 +60,35 @@ func TestDumpString(t *testing.T) {
 	// Test case 3: Struct
 	age := 30
 	person := Person{Name: "John Doe", age: &age}
-	expectedPerson := `Person{Name:"John Doe",age:&30,children:[]}`
+	expectedPerson := `v3:github.com/ahuigo/gofnext/examples.Person(Person{Name:"John Doe",age:&30,children:null})`
 	if result := serial.String(person, false); result != expectedPerson {
 		t.Errorf("Expected %s, but got %s", expectedPerson, result)
 	}
 
 	// Test case 7: pointer
 	p := &person
-	expectedP := "&Person{Name:\"John Doe\",age:&30,children:[]}"
+	expectedP := `v3:*github.com/ahuigo/gofnext/examples.Person(&Person{Name:"John Doe",age:&30,children:null})`
 	if result := serial.String(p, false); result != expectedP {
 		t.Errorf("Expected %s, but got %s", expectedP, result)
 	}
 
 	// Test case 4: Slice
 	slice := []int{1, 2, 3, 4, 5}
-	expectedSlice := "[1,2,3,4,5]"
+	expectedSlice := "v3:[]int([1,2,3,4,5])"
 	if result := serial.String(slice, false); result != expectedSlice {
 		t.Errorf("Expected %s, but got %s", expectedSlice, result)
 	}
 
 	// Test case 5: Map(multi)
 	m := map[string]int{"a": 1, "b": 2, "c": 3}
-	expectedMap := `{"a":1,"b":2,"c":3}`
+	expectedMap := `v3:map[string]int({"a":1,"b":2,"c":3})`
 	if result := serial.String(m, false); result != expectedMap {
 		t.Errorf("Expected %s, but got %s", expectedMap, result)
 	}
 
 	// Test case 6: interface{}
 	var i any = 42
-	expectedI := "42"
+	expectedI := "v3:int(42)"
 	if result := serial.String(i, false); result != expectedI {
 		t.Errorf("Expected %s, but got %s", expectedI, result)
 	}
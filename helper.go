@@ -1,6 +1,8 @@
 package gofnext
 
 import (
+	"fmt"
+	"hash"
 	"log/slog"
 	"math/rand"
 	"os"
@@ -10,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ahuigo/gofnext/serial"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
@@ -82,3 +85,92 @@ func marshalMsgpack(v any) ([]byte, error) {
 func unmarshalMsgpack(data []byte, v any) error {
 	return msgpack.Unmarshal(data, v)
 }
+
+// loadMany derives the keys of args by HashKeyFunc of m(like the decorator), and loads them by loadKeys
+func loadMany(m cacheMapKeysLoader, digest func() hash.Hash, args []any) ([]CacheLoadResult, error) {
+	hashKeyFunc := dumpHashKey
+	if h, ok := m.(interface{ HashKeyFunc(...any) []byte }); ok {
+		hashKeyFunc = h.HashKeyFunc
+	}
+	keys := make([]any, len(args))
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if e, ok := r.(error); ok {
+					err = fmt.Errorf("gofnext: hash key: %w", e)
+				} else {
+					err = fmt.Errorf("gofnext: hash key: %v", r)
+				}
+			}
+		}()
+		for i, arg := range args {
+			keys[i] = string(digestKey(digest, hashKeyFunc(arg)))
+		}
+		return nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+	return m.loadKeys(keys)
+}
+
+// digestKey replaces the key derived by HashKeyFunc with its digest(see Config.HashKeyDigest), nil digest keeps the key
+func digestKey(digest func() hash.Hash, key []byte) []byte {
+	if digest == nil {
+		return key
+	}
+	h := digest()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// digestRawKey digests the raw key(parameters) without dumping it(see Config.HashKeyDigest)
+func digestRawKey(digest func() hash.Hash, raw any, hashPtrAddr bool) (string, error) {
+	h := digest()
+	sum, err := serial.HashErr(raw, &serial.HashOptions{HashPtrAddr: hashPtrAddr, New: func() hash.Hash { return h }})
+	if err != nil {
+		return "", err
+	}
+	return string(sum[:min(h.Size(), len(sum))]), nil
+}
+
+// loadKeysOf loads keys from m in one round trip if m is a cacheMapKeysLoader, otherwise one by one
+func loadKeysOf(m CacheMap, keys []any) ([]CacheLoadResult, error) {
+	if loader, ok := m.(cacheMapKeysLoader); ok {
+		return loader.loadKeys(keys)
+	}
+	results := make([]CacheLoadResult, len(keys))
+	for i, key := range keys {
+		r := &results[i]
+		if checked, ok := m.(CacheMapChecked); ok {
+			var backendErr error
+			if r.Value, r.HasCache, r.Alive, r.Err, backendErr = checked.LoadChecked(key); backendErr != nil {
+				return results, backendErr
+			}
+		} else {
+			r.Value, r.HasCache, r.Alive, r.Err = m.Load(key)
+		}
+	}
+	return results, nil
+}
+
+// dumpHashKey dumps the function's parameters into a key(used by CacheMaps whose keys should be strings).
+// It panics with the error of dumpHashKeyErr, which is recovered by the decorator(see Config.OnKeyError)
+func dumpHashKey(key ...any) []byte {
+	data, err := dumpHashKeyErr(key...)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// dumpHashKeyErr is like dumpHashKey, but returns an error instead of panicking(e.g. serial.ErrUnsupportedKind)
+func dumpHashKeyErr(key ...any) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, nil
+	} else if len(key) == 1 {
+		return serial.BytesErr(key[0], false)
+	} else {
+		return serial.BytesErr(key, false)
+	}
+}
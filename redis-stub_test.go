@@ -0,0 +1,453 @@
+package gofnext
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// redisStub is a local stand-in redis server which supports a small subset of commands.
+type redisStub struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	hashes map[string]map[string]string
+	strs   map[string]stubString
+	conns  map[*stubConn]struct{}
+	subs   map[string]map[*stubConn]struct{}
+	calls  map[string]int // command -> count
+	// cluster rejects multi-key commands whose keys belong to different slots(like redis cluster)
+	cluster bool
+}
+
+type stubString struct {
+	val      string
+	expireAt time.Time
+}
+
+type stubConn struct {
+	net.Conn
+	wmu sync.Mutex
+}
+
+func newRedisStub(t *testing.T) *redisStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &redisStub{
+		ln:     ln,
+		hashes: map[string]map[string]string{},
+		strs:   map[string]stubString{},
+		conns:  map[*stubConn]struct{}{},
+		subs:   map[string]map[*stubConn]struct{}{},
+		calls:  map[string]int{},
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.DropConns()
+	})
+	return s
+}
+
+func (s *redisStub) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *redisStub) Client() redis.UniversalClient {
+	return redis.NewClient(&redis.Options{
+		Addr:            s.Addr(),
+		MaxRetries:      2,
+		MinRetryBackoff: time.Millisecond,
+		MaxRetryBackoff: 10 * time.Millisecond,
+	})
+}
+
+// DropConns closes all client connections(to simulate network errors)
+func (s *redisStub) DropConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// PTTL returns the remaining time to live of a string key(-1: no expiration, -2: not found)
+func (s *redisStub) PTTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.getString(key)
+	if !ok {
+		return -2
+	} else if v.expireAt.IsZero() {
+		return -1
+	}
+	return time.Until(v.expireAt)
+}
+
+func (s *redisStub) getString(key string) (stubString, bool) {
+	v, ok := s.strs[key]
+	if ok && !v.expireAt.IsZero() && time.Now().After(v.expireAt) {
+		delete(s.strs, key)
+		return v, false
+	}
+	return v, ok
+}
+
+// Subscribers returns the number of connections subscribed to channel
+// Calls returns how many times the command is executed
+func (s *redisStub) Calls(cmd string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[cmd]
+}
+
+func (s *redisStub) Subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs[channel])
+}
+
+func (s *redisStub) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &stubConn{Conn: conn}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go s.handle(c)
+	}
+}
+
+func (s *redisStub) handle(c *stubConn) {
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		for _, subs := range s.subs {
+			delete(subs, c)
+		}
+		s.mu.Unlock()
+	}()
+	rd := bufio.NewReader(c)
+	for {
+		args, err := readStubCommand(rd)
+		if err != nil {
+			return
+		}
+		reply := s.exec(c, args)
+		c.wmu.Lock()
+		_, err = c.Write(reply)
+		c.wmu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readStubCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, _ := strconv.Atoi(line[1:])
+	args := make([]string, n)
+	for i := range args {
+		line, err = rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimRight(line[1:], "\r\n"))
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func stubBulk(s string) []byte {
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(s), s))
+}
+
+func stubInt(n int) []byte {
+	return []byte(fmt.Sprintf(":%d\r\n", n))
+}
+
+func stubArray(items ...[]byte) []byte {
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(items)))
+	for _, item := range items {
+		buf = append(buf, item...)
+	}
+	return buf
+}
+
+var (
+	stubOK  = []byte("+OK\r\n")
+	stubNil = []byte("$-1\r\n")
+)
+
+func (s *redisStub) exec(c *stubConn, args []string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(args) == 0 {
+		return []byte("-ERR empty command\r\n")
+	}
+	s.calls[strings.ToLower(args[0])]++
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		if len(s.subscribedChannels(c)) > 0 {
+			return stubArray(stubBulk("pong"), stubBulk(""))
+		}
+		return []byte("+PONG\r\n")
+	case "hget":
+		if v, ok := s.hashes[args[1]][args[2]]; ok {
+			return stubBulk(v)
+		}
+		return stubNil
+	case "hmget":
+		items := make([][]byte, 0, len(args)-2)
+		for _, field := range args[2:] {
+			if v, ok := s.hashes[args[1]][field]; ok {
+				items = append(items, stubBulk(v))
+			} else {
+				items = append(items, stubNil)
+			}
+		}
+		return stubArray(items...)
+	case "hset":
+		if s.hashes[args[1]] == nil {
+			s.hashes[args[1]] = map[string]string{}
+		}
+		s.hashes[args[1]][args[2]] = args[3]
+		return stubInt(1)
+	case "hdel":
+		n := 0
+		for _, field := range args[2:] {
+			if _, ok := s.hashes[args[1]][field]; ok {
+				delete(s.hashes[args[1]], field)
+				n++
+			}
+		}
+		return stubInt(n)
+	case "del":
+		if s.cluster && !stubSameSlot(args[1:]) {
+			return []byte("-CROSSSLOT Keys in request don't hash to the same slot\r\n")
+		}
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := s.hashes[key]; ok {
+				delete(s.hashes, key)
+				n++
+			}
+			if _, ok := s.getString(key); ok {
+				delete(s.strs, key)
+				n++
+			}
+		}
+		return stubInt(n)
+	case "get":
+		if v, ok := s.getString(args[1]); ok {
+			return stubBulk(v.val)
+		}
+		return stubNil
+	case "set":
+		v := stubString{val: args[2]}
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "px", "ex":
+				n, _ := strconv.Atoi(args[i+1])
+				unit := time.Millisecond
+				if strings.ToLower(args[i]) == "ex" {
+					unit = time.Second
+				}
+				v.expireAt = time.Now().Add(time.Duration(n) * unit)
+				i++
+			case "nx":
+				nx = true
+			}
+		}
+		if _, ok := s.getString(args[1]); ok && nx {
+			return stubNil
+		}
+		s.strs[args[1]] = v
+		return stubOK
+	case "scan":
+		// return all matched keys in one batch
+		pattern := "*"
+		for i := 2; i < len(args)-1; i++ {
+			if strings.ToLower(args[i]) == "match" {
+				pattern = args[i+1]
+			}
+		}
+		var keys [][]byte
+		for key := range s.strs {
+			if _, ok := s.getString(key); ok && stubMatch(pattern, key) {
+				keys = append(keys, stubBulk(key))
+			}
+		}
+		for key := range s.hashes {
+			if stubMatch(pattern, key) {
+				keys = append(keys, stubBulk(key))
+			}
+		}
+		return stubArray(stubBulk("0"), stubArray(keys...))
+	case "publish":
+		subs := s.subs[args[1]]
+		msg := stubArray(stubBulk("message"), stubBulk(args[1]), stubBulk(args[2]))
+		for sub := range subs {
+			sub.wmu.Lock()
+			_, _ = sub.Write(msg)
+			sub.wmu.Unlock()
+		}
+		return stubInt(len(subs))
+	case "subscribe":
+		var reply []byte
+		for _, channel := range args[1:] {
+			if s.subs[channel] == nil {
+				s.subs[channel] = map[*stubConn]struct{}{}
+			}
+			s.subs[channel][c] = struct{}{}
+			reply = append(reply, stubArray(stubBulk("subscribe"), stubBulk(channel), stubInt(len(s.subscribedChannels(c))))...)
+		}
+		return reply
+	case "eval":
+		numKeys, _ := strconv.Atoi(args[2])
+		if s.cluster && !stubSameSlot(args[3:3+numKeys]) {
+			return []byte("-CROSSSLOT Keys in request don't hash to the same slot\r\n")
+		}
+		return s.eval(args[1], args[3:3+numKeys], args[3+numKeys:])
+	default:
+		return []byte(fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0]))
+	}
+}
+
+// eval emulates the lua scripts used by gofnext
+func (s *redisStub) eval(script string, keys, argv []string) []byte {
+	switch script {
+	case leaseAcquireScript:
+		if _, ok := s.getString(keys[0]); ok {
+			return stubInt(0)
+		}
+		fence, _ := s.getString(keys[1])
+		token, _ := strconv.Atoi(fence.val)
+		token++
+		leaseMs, _ := strconv.Atoi(argv[0])
+		fenceMs, _ := strconv.Atoi(argv[1])
+		s.strs[keys[1]] = stubString{val: strconv.Itoa(token), expireAt: time.Now().Add(time.Duration(fenceMs) * time.Millisecond)}
+		s.strs[keys[0]] = stubString{val: strconv.Itoa(token), expireAt: time.Now().Add(time.Duration(leaseMs) * time.Millisecond)}
+		return stubInt(token)
+	case leaseStoreScript:
+		if v, ok := s.getString(keys[0]); !ok || v.val != argv[0] {
+			return stubInt(0)
+		}
+		if len(argv) == 4 {
+			if s.hashes[keys[1]] == nil {
+				s.hashes[keys[1]] = map[string]string{}
+			}
+			s.hashes[keys[1]][argv[3]] = argv[1]
+		} else {
+			v := stubString{val: argv[1]}
+			if ms, _ := strconv.Atoi(argv[2]); ms > 0 {
+				v.expireAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+			s.strs[keys[1]] = v
+		}
+		return stubInt(1)
+	case leaseReleaseScript:
+		if v, ok := s.getString(keys[0]); ok && v.val == argv[0] {
+			delete(s.strs, keys[0])
+			return stubInt(1)
+		}
+		return stubInt(0)
+	}
+	return []byte("-ERR unknown script\r\n")
+}
+
+func stubSameSlot(keys []string) bool {
+	for _, key := range keys {
+		if stubKeySlot(key) != stubKeySlot(keys[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// stubKeySlot is the hash slot of key in redis cluster: crc16(key or its {hash tag}) % 16384
+func stubKeySlot(key string) uint16 {
+	key = slotTag(key)
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+func (s *redisStub) subscribedChannels(c *stubConn) (channels []string) {
+	for channel, subs := range s.subs {
+		if _, ok := subs[c]; ok {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// stubMatch matches redis glob-style pattern(supports `*`, `?` and `\\` escapes)
+func stubMatch(pattern, s string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(s); i++ {
+			if stubMatch(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return s != "" && stubMatch(pattern[1:], s[1:])
+	case '\\':
+		if len(pattern) > 1 {
+			pattern = pattern[1:]
+		}
+	}
+	return s != "" && s[0] == pattern[0] && stubMatch(pattern[1:], s[1:])
+}
+
+// waitFor polls cond until it returns true
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
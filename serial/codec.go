@@ -0,0 +1,527 @@
+package serial
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+/*
+Marshal encodes any value into bytes losslessly(include private field), it can be decoded by Unmarshal:
+  - unexported fields, pointers, maps, slices and nested structs are encoded.
+  - shared and cyclic pointers(or maps) are encoded as back references, so they are restored as shared.
+  - interface values are encoded with their types. Named types should be registered by RegisterType
+    to be decoded in another process(types encoded or decoded in this process are registered automatically).
+  - non-nil func, chan and unsafe.Pointer are not supported.
+*/
+func Marshal(val any) (data []byte, err error) {
+	e := &encoder{ptrs: map[ptrKey]int{}}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("serial.Marshal: %v", r)
+		}
+	}()
+	if val == nil {
+		e.writeType(nil)
+		return e.buf, nil
+	}
+	refV := addressable(reflect.ValueOf(val))
+	e.writeType(refV.Type())
+	e.encode(refV, 0)
+	return e.buf, nil
+}
+
+// Unmarshal decodes data(encoded by Marshal) into v, v should be a non-nil pointer.
+func Unmarshal(data []byte, v any) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("serial.Unmarshal: non-nil pointer is required")
+	}
+	d := &decoder{data: data}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("serial.Unmarshal: %v", r)
+		}
+	}()
+	registerTypes(rv.Elem().Type())
+	t := d.readType()
+	if t == nil {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		return nil
+	}
+	if rv.Elem().Kind() != reflect.Interface && t.Kind() != rv.Elem().Kind() {
+		return fmt.Errorf("serial.Unmarshal: cannot decode %s into %s", t, rv.Elem().Type())
+	}
+	if rv.Elem().Kind() == reflect.Interface {
+		// decode again with the type descriptor
+		var nv any
+		d.pos = 0
+		d.decode(reflect.ValueOf(&nv).Elem(), 0)
+		if !reflect.TypeOf(nv).AssignableTo(rv.Elem().Type()) {
+			return fmt.Errorf("serial.Unmarshal: cannot decode %s into %s", t, rv.Elem().Type())
+		}
+		rv.Elem().Set(reflect.ValueOf(nv))
+	} else {
+		d.decode(rv.Elem(), 0)
+	}
+	return nil
+}
+
+/*********************** type registry ***********************/
+var typeRegistry sync.Map // name -> reflect.Type
+
+// RegisterType registers the named type of val, so that it can be decoded from interface values.
+func RegisterType(val any) {
+	registerTypes(reflect.TypeOf(val))
+}
+
+func typeName(t reflect.Type) string {
+	return t.PkgPath() + "." + t.Name()
+}
+
+// registerTypes registers t and named types used by t.
+func registerTypes(t reflect.Type) {
+	seen := map[reflect.Type]bool{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		if t == nil || seen[t] {
+			return
+		}
+		seen[t] = true
+		if t.Name() != "" && t.PkgPath() != "" {
+			typeRegistry.LoadOrStore(typeName(t), t)
+		}
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			walk(t.Elem())
+		case reflect.Map:
+			walk(t.Key())
+			walk(t.Elem())
+		case reflect.Struct:
+			for i := 0; i < t.NumField(); i++ {
+				walk(t.Field(i).Type)
+			}
+		}
+	}
+	walk(t)
+}
+
+var basicTypes = map[reflect.Kind]reflect.Type{
+	reflect.Bool:       reflect.TypeOf(false),
+	reflect.Int:        reflect.TypeOf(int(0)),
+	reflect.Int8:       reflect.TypeOf(int8(0)),
+	reflect.Int16:      reflect.TypeOf(int16(0)),
+	reflect.Int32:      reflect.TypeOf(int32(0)),
+	reflect.Int64:      reflect.TypeOf(int64(0)),
+	reflect.Uint:       reflect.TypeOf(uint(0)),
+	reflect.Uint8:      reflect.TypeOf(uint8(0)),
+	reflect.Uint16:     reflect.TypeOf(uint16(0)),
+	reflect.Uint32:     reflect.TypeOf(uint32(0)),
+	reflect.Uint64:     reflect.TypeOf(uint64(0)),
+	reflect.Uintptr:    reflect.TypeOf(uintptr(0)),
+	reflect.Float32:    reflect.TypeOf(float32(0)),
+	reflect.Float64:    reflect.TypeOf(float64(0)),
+	reflect.Complex64:  reflect.TypeOf(complex64(0)),
+	reflect.Complex128: reflect.TypeOf(complex128(0)),
+	reflect.String:     reflect.TypeOf(""),
+	reflect.Interface:  reflect.TypeOf((*any)(nil)).Elem(),
+}
+
+const maxDepth = 10000
+
+/*********************** encoder ***********************/
+type ptrKey struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+type encoder struct {
+	buf  []byte
+	ptrs map[ptrKey]int // shared pointers(or maps) -> id
+}
+
+func (e *encoder) writeUvarint(n uint64) {
+	e.buf = binary.AppendUvarint(e.buf, n)
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+/*
+writeType writes type descriptor:
+  - nil: 0
+  - named type: 'N' + name + descriptor of its structure
+  - basic type: kind
+  - composite type: kind + element descriptors
+*/
+func (e *encoder) writeType(t reflect.Type) {
+	if t == nil {
+		e.buf = append(e.buf, 0)
+		return
+	}
+	if t.Name() != "" && t.PkgPath() != "" {
+		e.buf = append(e.buf, 'N')
+		e.writeString(typeName(t))
+		typeRegistry.LoadOrStore(typeName(t), t)
+	}
+	e.buf = append(e.buf, byte(t.Kind()))
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		e.writeType(t.Elem())
+	case reflect.Array:
+		e.writeUvarint(uint64(t.Len()))
+		e.writeType(t.Elem())
+	case reflect.Map:
+		e.writeType(t.Key())
+		e.writeType(t.Elem())
+	}
+}
+
+func (e *encoder) encode(v reflect.Value, depth int) {
+	if depth > maxDepth {
+		panic("exceeded max depth")
+	}
+	v = accessible(v)
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.buf = binary.AppendVarint(e.buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUvarint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(real(c)))
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(imag(c)))
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.writeUvarint(0)
+			return
+		}
+		e.writeUvarint(uint64(v.Len()) + 1)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.buf = append(e.buf, v.Bytes()...)
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			e.encode(v.Index(i), depth+1)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			e.encode(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		if !e.writeRef(v) {
+			return
+		}
+		e.writeUvarint(uint64(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			e.encode(addressable(iter.Key()), depth+1)
+			e.encode(addressable(iter.Value()), depth+1)
+		}
+	case reflect.Ptr:
+		if !e.writeRef(v) {
+			return
+		}
+		e.encode(v.Elem(), depth+1)
+	case reflect.Interface:
+		if v.IsNil() {
+			e.writeType(nil)
+			return
+		}
+		elem := addressable(v.Elem())
+		e.writeType(elem.Type())
+		e.encode(elem, depth+1)
+	case reflect.Struct:
+		// fields are encoded by name with length prefix, so that changed fields can be skipped
+		t := v.Type()
+		e.writeUvarint(uint64(v.NumField()))
+		for i := 0; i < v.NumField(); i++ {
+			e.writeString(t.Field(i).Name)
+			sub := &encoder{ptrs: e.ptrs}
+			sub.encode(v.Field(i), depth+1)
+			e.writeUvarint(uint64(len(sub.buf)))
+			e.buf = append(e.buf, sub.buf...)
+		}
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		if !v.IsNil() {
+			panic(fmt.Sprintf("unsupported kind %s", v.Kind()))
+		}
+	default:
+		panic(fmt.Sprintf("unsupported kind %s", v.Kind()))
+	}
+}
+
+/*
+writeRef writes the reference tag of pointer(or map):
+  - 0: nil
+  - 1 + id: first occurrence, its value follows(returns true)
+  - 2 + id: back reference to a seen pointer
+*/
+func (e *encoder) writeRef(v reflect.Value) bool {
+	if v.IsNil() {
+		e.buf = append(e.buf, 0)
+		return false
+	}
+	key := ptrKey{v.Pointer(), v.Type()}
+	if id, ok := e.ptrs[key]; ok {
+		e.buf = append(e.buf, 2)
+		e.writeUvarint(uint64(id))
+		return false
+	}
+	id := len(e.ptrs)
+	e.ptrs[key] = id
+	e.buf = append(e.buf, 1)
+	e.writeUvarint(uint64(id))
+	return true
+}
+
+// accessible makes the value obtained via unexported fields readable and writable
+func accessible(v reflect.Value) reflect.Value {
+	if !v.CanInterface() && v.CanAddr() {
+		return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+	}
+	return v
+}
+
+// addressable copies the value(e.g. map's element) so that its fields can be made accessible
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	nv := reflect.New(v.Type()).Elem()
+	nv.Set(v)
+	return nv
+}
+
+/*********************** decoder ***********************/
+type decoder struct {
+	data []byte
+	pos  int
+	refs []reflect.Value // id -> pointer(or map)
+}
+
+func (d *decoder) readByte() byte {
+	if d.pos >= len(d.data) {
+		panic("unexpected end of data")
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *decoder) readUvarint() uint64 {
+	n, size := binary.Uvarint(d.data[d.pos:])
+	if size <= 0 {
+		panic("invalid uvarint")
+	}
+	d.pos += size
+	return n
+}
+
+func (d *decoder) readVarint() int64 {
+	n, size := binary.Varint(d.data[d.pos:])
+	if size <= 0 {
+		panic("invalid varint")
+	}
+	d.pos += size
+	return n
+}
+
+func (d *decoder) readBytes(n int) []byte {
+	if n < 0 || d.pos+n > len(d.data) {
+		panic("unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) readString() string {
+	return string(d.readBytes(int(d.readUvarint())))
+}
+
+func (d *decoder) readUint64() uint64 {
+	return binary.LittleEndian.Uint64(d.readBytes(8))
+}
+
+// readType reads type descriptor written by writeType
+func (d *decoder) readType() reflect.Type {
+	b := d.readByte()
+	if b == 0 {
+		return nil
+	}
+	var named reflect.Type
+	name := ""
+	if b == 'N' {
+		name = d.readString()
+		if t, ok := typeRegistry.Load(name); ok {
+			named = t.(reflect.Type)
+		}
+		b = d.readByte()
+	}
+	kind := reflect.Kind(b)
+	var t reflect.Type
+	switch kind {
+	case reflect.Ptr:
+		t = reflect.PointerTo(d.readType())
+	case reflect.Slice:
+		t = reflect.SliceOf(d.readType())
+	case reflect.Array:
+		n := int(d.readUvarint())
+		t = reflect.ArrayOf(n, d.readType())
+	case reflect.Map:
+		key := d.readType()
+		t = reflect.MapOf(key, d.readType())
+	case reflect.Struct:
+		if named == nil {
+			panic(fmt.Sprintf("unregistered type %s(see serial.RegisterType)", name))
+		}
+	default:
+		if basicTypes[kind] == nil {
+			panic(fmt.Sprintf("unsupported kind %s", kind))
+		}
+		t = basicTypes[kind]
+	}
+	if named != nil {
+		return named
+	}
+	return t
+}
+
+func (d *decoder) decode(v reflect.Value, depth int) {
+	if depth > maxDepth {
+		panic("exceeded max depth")
+	}
+	v = accessible(v)
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(d.readByte() == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(d.readVarint())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(d.readUvarint())
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(math.Float64frombits(d.readUint64()))
+	case reflect.Complex64, reflect.Complex128:
+		r := math.Float64frombits(d.readUint64())
+		i := math.Float64frombits(d.readUint64())
+		v.SetComplex(complex(r, i))
+	case reflect.String:
+		v.SetString(d.readString())
+	case reflect.Slice:
+		n := int(d.readUvarint())
+		if n == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		n--
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := reflect.MakeSlice(v.Type(), n, n)
+			reflect.Copy(b, reflect.ValueOf(d.readBytes(n)))
+			v.Set(b)
+			return
+		}
+		if n > len(d.data)-d.pos {
+			panic("invalid slice length")
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		for i := 0; i < n; i++ {
+			d.decode(v.Index(i), depth+1)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			d.decode(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		m, isNew := d.readRef(v.Type(), func() reflect.Value { return reflect.MakeMap(v.Type()) })
+		v.Set(m)
+		if !isNew {
+			return
+		}
+		n := int(d.readUvarint())
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			d.decode(key, depth+1)
+			val := reflect.New(v.Type().Elem()).Elem()
+			d.decode(val, depth+1)
+			m.SetMapIndex(key, val)
+		}
+	case reflect.Ptr:
+		p, isNew := d.readRef(v.Type(), func() reflect.Value { return reflect.New(v.Type().Elem()) })
+		v.Set(p)
+		if isNew {
+			d.decode(p.Elem(), depth+1)
+		}
+	case reflect.Interface:
+		t := d.readType()
+		if t == nil {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		nv := reflect.New(t).Elem()
+		d.decode(nv, depth+1)
+		if !nv.Type().AssignableTo(v.Type()) {
+			panic(fmt.Sprintf("cannot assign %s to %s", nv.Type(), v.Type()))
+		}
+		v.Set(nv)
+	case reflect.Struct:
+		t := v.Type()
+		n := int(d.readUvarint())
+		for i := 0; i < n; i++ {
+			name := d.readString()
+			size := int(d.readUvarint())
+			end := d.pos + size
+			if field, ok := t.FieldByName(name); ok && len(field.Index) == 1 {
+				d.decode(v.FieldByIndex(field.Index), depth+1)
+			}
+			// skip unknown(removed) fields
+			d.pos = end
+		}
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		// only nil is encoded
+	default:
+		panic(fmt.Sprintf("unsupported kind %s", v.Kind()))
+	}
+}
+
+/*
+readRef reads the reference tag written by writeRef:
+returns the new pointer(or map) created by newFn, or the seen one.
+*/
+func (d *decoder) readRef(t reflect.Type, newFn func() reflect.Value) (v reflect.Value, isNew bool) {
+	switch d.readByte() {
+	case 0:
+		return reflect.Zero(t), false
+	case 1:
+		id := int(d.readUvarint())
+		if id != len(d.refs) {
+			panic("invalid reference id")
+		}
+		v = newFn()
+		d.refs = append(d.refs, v)
+		return v, true
+	case 2:
+		id := int(d.readUvarint())
+		if id >= len(d.refs) || d.refs[id].Type() != t {
+			panic("invalid back reference")
+		}
+		return d.refs[id], false
+	}
+	panic("invalid reference tag")
+}
@@ -0,0 +1,112 @@
+package serial
+
+import (
+	"reflect"
+	"testing"
+)
+
+type codecStu struct {
+	Age     int
+	private int
+}
+
+type codecNode struct {
+	Name  string
+	next  *codecNode
+	attrs map[string]any
+}
+
+func TestMarshalPrivateField(t *testing.T) {
+	type M = map[string]any
+	type Data struct {
+		M M
+	}
+	RegisterType(codecStu{})
+	data := &Data{M: M{
+		"i8":  int8(98),
+		"u8":  uint8(98),
+		"f32": float32(2),
+		"stu": codecStu{Age: 18, private: 1},
+		"nil": nil,
+	}}
+	b, err := Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *Data
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Fatalf("got %#v, want %#v", got, data)
+	}
+	// types of interface values are kept
+	if got.M["stu"].(codecStu).private != 1 {
+		t.Fatalf("private field should be decoded")
+	}
+}
+
+func TestMarshalSharedPointer(t *testing.T) {
+	a := &codecNode{Name: "a"}
+	b := &codecNode{Name: "b", next: a, attrs: map[string]any{"bytes": []byte("x")}}
+	a.next = b // cycle
+	nodes := []*codecNode{a, b}
+
+	data, err := Marshal(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*codecNode
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].next != got[1] || got[1].next != got[0] {
+		t.Fatal("shared pointers should be restored")
+	}
+	if string(got[1].attrs["bytes"].([]byte)) != "x" {
+		t.Fatalf("unexpected attrs: %v", got[1].attrs)
+	}
+}
+
+func TestMarshalUnregisteredStruct(t *testing.T) {
+	type unregistered struct {
+		Name string
+		age  int
+	}
+	data, err := Marshal(map[string]any{"u": unregistered{Name: "Alex", age: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// simulate another process
+	typeRegistry.Delete(typeName(reflect.TypeOf(unregistered{})))
+
+	var got map[string]any
+	if err := Unmarshal(data, &got); err == nil {
+		t.Fatal("unregistered type should return error")
+	}
+
+	// static types are registered when decoding
+	var u unregistered
+	data, _ = Marshal(unregistered{Name: "Alex", age: 20})
+	typeRegistry.Delete(typeName(reflect.TypeOf(unregistered{})))
+	if err := Unmarshal(data, &u); err != nil || u.age != 20 {
+		t.Fatalf("unexpected value %#v(%v)", u, err)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	if _, err := Marshal(struct{ F func() }{F: func() {}}); err == nil {
+		t.Fatal("func should not be supported")
+	}
+	var i int
+	if err := Unmarshal([]byte{1, 2}, i); err == nil {
+		t.Fatal("non-pointer should return error")
+	}
+	data, _ := Marshal("str")
+	if err := Unmarshal(data, &i); err == nil {
+		t.Fatal("mismatched kind should return error")
+	}
+	if err := Unmarshal(data[:2], new(string)); err == nil {
+		t.Fatal("truncated data should return error")
+	}
+}
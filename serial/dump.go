@@ -2,145 +2,354 @@ package serial
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"slices"
 	"strconv"
+	"sync"
 )
 
-type PtrSeen map[uintptr]struct{}
+/*
+Version is the version of the canonical encoding dumped by String/Bytes, it is changed when the encoding changes.
 
-func (ps PtrSeen) Add(rv reflect.Value) bool {
-	ptr := rv.Pointer()
-	if _, ok := ps[ptr]; ok {
-		// e := fmt.Sprintf("encountered a cycle via %s", rv.Type())
-		// panic(e)
-		return false
+The encoding is unambiguous(distinct values never dump identically):
+  - dumps start with the version, and the root value is tagged with its type: v3:int(1)
+  - type names are qualified by package path: github.com/pkg/user.User
+  - strings are quoted and escaped: "a\"b"
+  - floats are dumped with exact(shortest round-trip) digits: 1e-09, -0
+  - values held by interfaces are tagged with their types: int8(1), string("1")
+  - nil pointers, interfaces, slices and maps are dumped as null
+  - struct fields excluded by TagName are skipped
+  - CacheKeyer values are dumped as type#key: *example.com/pkg.UserInfo#"1"
+  - values of well-known types(see RegisterNormalizer) are dumped as type#normalized: time.Time#"2024-01-01T00:00:00Z"
+  - every token is self-delimiting(quoted strings, bracketed containers), so no length prefix is needed
+*/
+const Version = 3
+
+var versionPrefix = "v" + strconv.Itoa(Version) + ":"
+
+/*
+PtrPath is the references(pointers, slices and maps) on the current path from the root.
+Only real cycles are detected: a value which references the same pointer twice(e.g. two fields point to one config)
+is dumped twice, and a cycle is dumped as a back reference to its ancestor, e.g. <cycle pointer ^2> refers to
+the reference 2 levels up.
+Since shared values are dumped at every reference, a deep DAG(e.g. a chain of diamonds) is limited by MaxRefs.
+*/
+type PtrPath []ptrRef
+
+type ptrRef struct {
+	ptr uintptr
+	len int // slices sharing the same array are different if their lengths are different
+	typ reflect.Type
+}
+
+func newPtrRef(rv reflect.Value) ptrRef {
+	ref := ptrRef{ptr: rv.Pointer(), typ: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		ref.len = rv.Len()
 	}
-	ps[ptr] = struct{}{}
-	return true
+	return ref
+}
+
+// Push adds rv to the path, it returns how many levels up rv is found on the path(0: not a cycle)
+func (pp *PtrPath) Push(rv reflect.Value) (up int) {
+	ref := newPtrRef(rv)
+	for i := len(*pp) - 1; i >= 0; i-- {
+		if (*pp)[i] == ref {
+			return len(*pp) - i
+		}
+	}
+	*pp = append(*pp, ref)
+	return 0
+}
+
+// Pop removes the last reference from the path
+func (pp *PtrPath) Pop() {
+	*pp = (*pp)[:len(*pp)-1]
 }
 
 // Dump any value to string(include private field)
 func String(val any, hashPtrAddr bool) string {
-	refV := reflect.ValueOf(val)
-	ps := PtrSeen{}
-	return string(dump(refV, hashPtrAddr, ps))
+	return string(Bytes(val, hashPtrAddr))
 }
 
-// Dump any value to bytes(include private field)
+// Dump any value to bytes(include private field), it panics if val can't be dumped(see BytesErr)
 func Bytes(val any, hashPtrAddr bool) []byte {
-	refV := reflect.ValueOf(val)
-	ps := PtrSeen{}
-	return dump(refV, hashPtrAddr, ps)
+	data, err := BytesErr(val, hashPtrAddr)
+	if err != nil {
+		panic(err)
+	}
+	return data
 }
 
-func dump(refV reflect.Value, hashPtrAddr bool, ps PtrSeen) []byte {
+// BytesErr is like Bytes, but returns an error instead of panicking(e.g. ErrUnsupportedKind)
+func BytesErr(val any, hashPtrAddr bool) ([]byte, error) {
 	var buf bytes.Buffer
+	d := dumper{w: &buf, hashPtrAddr: hashPtrAddr, ps: &PtrPath{}}
+	if err := d.dumpValue(reflect.ValueOf(val)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrUnsupportedKind is returned when a value of unsupported kind(e.g. unsafe.Pointer) is dumped
+var ErrUnsupportedKind = errors.New("serial: unsupported kind")
+
+// ErrTooManyRefs is returned when a value follows more than MaxRefs references(pointers, slices and maps)
+var ErrTooManyRefs = errors.New("serial: too many references")
+
+// MaxRefs limits the references followed by one dump, shared values are counted at every reference
+var MaxRefs = 1 << 20
+
+// dumpWriter is implemented by *bytes.Buffer and *bufio.Writer
+type dumpWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// dumper writes the canonical dump of values into w without building intermediate strings
+type dumper struct {
+	w           dumpWriter
+	hashPtrAddr bool
+	ps          *PtrPath
+	refs        int // references followed so far(see MaxRefs)
+	scratch     [64]byte
+}
+
+// push adds refV to the path(see PtrPath.Push), it panics with ErrTooManyRefs if MaxRefs is exceeded
+func (d *dumper) push(refV reflect.Value) (up int) {
+	if d.refs++; d.refs > MaxRefs {
+		panic(fmt.Errorf("%w(more than %d)", ErrTooManyRefs, MaxRefs))
+	}
+	return d.ps.Push(refV)
+}
+
+// dumpValue dumps refV, the panics of dump(and CacheKey methods) are returned as error
+func (d *dumper) dumpValue(refV reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok && (errors.Is(e, ErrUnsupportedKind) || errors.Is(e, ErrTooManyRefs)) {
+				err = e
+			} else {
+				err = fmt.Errorf("serial: %v", r)
+			}
+		}
+	}()
+	d.w.WriteString(versionPrefix)
+	if !refV.IsValid() {
+		d.w.WriteString("<invalid>")
+		return nil
+	}
+	// tag the root value with its type, e.g. f(1) and f(1.0) of func f(any) are different keys
+	refV = exposed(refV)
+	d.w.WriteString(typeString(refV.Type()))
+	d.w.WriteByte('(')
+	d.dump(refV)
+	d.w.WriteByte(')')
+	return nil
+}
+
+var typeStrings sync.Map // reflect.Type -> string
 
+// typeString is like reflect.Type.String, but named types are qualified by package path: []*github.com/pkg.User
+func typeString(typ reflect.Type) string {
+	if s, ok := typeStrings.Load(typ); ok {
+		return s.(string)
+	}
+	var s string
+	switch {
+	case typ.Name() != "":
+		s = typ.Name()
+		if typ.PkgPath() != "" {
+			s = typeName(typ)
+		}
+	case typ.Kind() == reflect.Ptr:
+		s = "*" + typeString(typ.Elem())
+	case typ.Kind() == reflect.Slice:
+		s = "[]" + typeString(typ.Elem())
+	case typ.Kind() == reflect.Array:
+		s = "[" + strconv.Itoa(typ.Len()) + "]" + typeString(typ.Elem())
+	case typ.Kind() == reflect.Map:
+		s = "map[" + typeString(typ.Key()) + "]" + typeString(typ.Elem())
+	default:
+		s = typ.String()
+	}
+	typeStrings.Store(typ, s)
+	return s
+}
+
+func (d *dumper) dump(refV reflect.Value) {
+	w := d.w
+	if refV.IsValid() && !isNilRef(refV) && d.dumpCustom(refV) {
+		return
+	}
 	switch refV.Kind() {
 	case reflect.Invalid:
-		buf.WriteString("<invalid>")
+		w.WriteString("<invalid>")
 	case reflect.String:
-		buf.WriteString(`"`)
-		buf.WriteString(refV.String())
-		buf.WriteString(`"`)
+		w.Write(strconv.AppendQuote(d.scratch[:0], refV.String()))
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		buf.WriteString(fmt.Sprintf("%d", refV.Int()))
+		w.Write(strconv.AppendInt(d.scratch[:0], refV.Int(), 10))
 	// refV.CanInt()
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		buf.WriteString(fmt.Sprintf("%d", refV.Uint()))
+		w.Write(strconv.AppendUint(d.scratch[:0], refV.Uint(), 10))
 	case reflect.Float32, reflect.Float64:
-		buf.WriteString(fmt.Sprintf("%f", refV.Float()))
+		w.Write(strconv.AppendFloat(d.scratch[:0], refV.Float(), 'g', -1, refV.Type().Bits()))
 	case reflect.Complex64, reflect.Complex128:
-		buf.WriteString(fmt.Sprintf("%f", refV.Complex()))
+		w.WriteString(strconv.FormatComplex(refV.Complex(), 'g', -1, refV.Type().Bits()))
 	case reflect.Ptr, reflect.Interface:
-		dumpPtrInterface(refV, hashPtrAddr, &buf, ps)
+		d.dumpPtrInterface(refV)
 	case reflect.Slice:
-		if !hashPtrAddr && !ps.Add(refV) {
-			buf.WriteString("<cycle slice>")
+		if refV.IsNil() {
+			w.WriteString("null")
+			break
+		}
+		if up := d.push(refV); up > 0 {
+			fmt.Fprintf(w, "<cycle slice ^%d>", up)
 			break
 		}
-		fallthrough
+		d.dumpSliceArray(refV)
+		d.ps.Pop()
 	case reflect.Array:
-		dumpSliceArray(refV, hashPtrAddr, &buf, ps)
+		d.dumpSliceArray(refV)
 	case reflect.Map:
-		if !hashPtrAddr && !ps.Add(refV) {
-			buf.WriteString("<cycle map>")
+		if refV.IsNil() {
+			w.WriteString("null")
 			break
 		}
-		dumpMap(refV, hashPtrAddr, &buf, ps)
+		if up := d.push(refV); up > 0 {
+			fmt.Fprintf(w, "<cycle map ^%d>", up)
+			break
+		}
+		d.dumpMap(refV)
+		d.ps.Pop()
 	case reflect.Struct:
-		dumpStruct(refV, hashPtrAddr, &buf, ps)
+		d.dumpStruct(refV)
 	case reflect.Func:
-		buf.WriteString("<func>")
+		if refV.IsNil() {
+			w.WriteString("null")
+		} else {
+			w.WriteString("<func>")
+		}
 	case reflect.Chan:
-		buf.WriteString("<chan>")
+		if refV.IsNil() {
+			w.WriteString("null")
+		} else {
+			// channels are compared by identity
+			fmt.Fprintf(w, "<chan 0x%x>", refV.Pointer())
+		}
 	case reflect.Bool:
-		buf.WriteString(strconv.FormatBool(refV.Bool()))
+		w.Write(strconv.AppendBool(d.scratch[:0], refV.Bool()))
 	default:
-		msg := fmt.Sprintf("unsupported kind %s", refV.Kind())
-		panic(msg)
+		panic(fmt.Errorf("%w %s", ErrUnsupportedKind, refV.Kind()))
 	}
+}
 
-	return buf.Bytes()
+// dumpCustom dumps values with normalizers or CacheKey as type#value, e.g. time.Time#"2024-01-01T00:00:00Z"
+func (d *dumper) dumpCustom(refV reflect.Value) bool {
+	typ := refV.Type()
+	if normalize := getNormalizer(typ); normalize != nil {
+		if val, ok := interfaceOf(refV); ok {
+			d.w.WriteString(typeString(typ))
+			d.w.WriteByte('#')
+			d.dump(exposed(reflect.ValueOf(normalize(val))))
+			return true
+		}
+	}
+	if key, ok := cacheKeyOf(refV, d.scratch[:0]); ok {
+		d.w.Write(key)
+		return true
+	}
+	return false
+}
+
+func isNilRef(refV reflect.Value) bool {
+	switch refV.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return refV.IsNil()
+	}
+	return false
 }
 
-func dumpPtrInterface(refV reflect.Value, hashPtrAddr bool, buf *bytes.Buffer, ps PtrSeen) {
+func (d *dumper) dumpPtrInterface(refV reflect.Value) {
+	w := d.w
 	if refV.IsNil() {
-		buf.WriteString("null")
+		w.WriteString("null")
 		return
 	}
 	isPtr := refV.Kind() == reflect.Ptr
-	if isPtr && !ps.Add(refV) {
-		buf.WriteString("<cycle pointer>")
-		return
-	}
-
-	if hashPtrAddr && isPtr {
-		buf.WriteString(fmt.Sprintf("*0x%x", refV.Pointer()))
+	if d.hashPtrAddr && isPtr {
+		fmt.Fprintf(w, "*0x%x", refV.Pointer())
+	} else if isPtr {
+		if up := d.push(refV); up > 0 {
+			fmt.Fprintf(w, "<cycle pointer ^%d>", up)
+			return
+		}
+		w.WriteByte('&')
+		d.dump(refV.Elem())
+		d.ps.Pop()
 	} else {
-		refV = refV.Elem()
-		buf.WriteString(fmt.Sprintf("&%s", dump(refV, hashPtrAddr, ps)))
+		// tag the value with its type, e.g. int8(1) and int(1) are different keys
+		refV = exposed(refV.Elem())
+		w.WriteString(typeString(refV.Type()))
+		w.WriteByte('(')
+		d.dump(refV)
+		w.WriteByte(')')
 	}
 }
 
-func dumpSliceArray(refV reflect.Value, hashPtrAddr bool, buf *bytes.Buffer, ps PtrSeen) {
-	buf.WriteString("[")
+func (d *dumper) dumpSliceArray(refV reflect.Value) {
+	d.w.WriteByte('[')
 	for i := 0; i < refV.Len(); i++ {
-		buf.Write(dump(refV.Index(i), hashPtrAddr, ps))
-		if i != refV.Len()-1 {
-			buf.WriteString(",")
+		if i > 0 {
+			d.w.WriteByte(',')
 		}
+		d.dump(refV.Index(i))
 	}
-	buf.WriteString("]")
+	d.w.WriteByte(']')
 }
 
-func dumpStruct(refV reflect.Value, hashPtrAddr bool, buf *bytes.Buffer, ps PtrSeen) {
-	name := refV.Type().Name()
-	buf.WriteString(name + "{")
-	for i := 0; i < refV.NumField(); i++ {
-		buf.WriteString(refV.Type().Field(i).Name)
-		buf.WriteString(":")
-		buf.Write(dump(refV.Field(i), hashPtrAddr, ps))
-		if i != refV.NumField()-1 {
-			buf.WriteString(",")
+func (d *dumper) dumpStruct(refV reflect.Value) {
+	typ := refV.Type()
+	d.w.WriteString(typ.Name())
+	d.w.WriteByte('{')
+	for n, i := range getStructInfo(typ).fields {
+		if n > 0 {
+			d.w.WriteByte(',')
 		}
+		d.w.WriteString(typ.Field(i).Name)
+		d.w.WriteByte(':')
+		d.dump(accessible(refV.Field(i)))
 	}
-	buf.WriteString("}")
+	d.w.WriteByte('}')
 }
 
-func dumpMap(refV reflect.Value, hashPtrAddr bool, buf *bytes.Buffer, ps PtrSeen) {
-	sli := make([][]byte, len(refV.MapKeys()))
-	for i, key := range refV.MapKeys() {
-		keyVal := append(dump(key, hashPtrAddr, ps), ':')
-		valbytes := dump(refV.MapIndex(key), hashPtrAddr, ps)
-		sli[i] = append(keyVal, valbytes...)
+// dumpMap buffers the entries(only the entries of the map) to sort them
+func (d *dumper) dumpMap(refV reflect.Value) {
+	w := d.w
+	sli := make([][]byte, 0, refV.Len())
+	iter := refV.MapRange()
+	for iter.Next() {
+		var buf bytes.Buffer
+		d.w = &buf
+		d.dump(exposed(iter.Key()))
+		buf.WriteByte(':')
+		d.dump(exposed(iter.Value()))
+		sli = append(sli, buf.Bytes())
 	}
+	d.w = w
 	slices.SortFunc(sli, func(a, b []byte) int {
 		return slices.Compare(a, b)
 	})
-	buf.WriteString("{")
-	buf.Write(bytes.Join(sli, []byte{','}))
-	buf.WriteString("}")
+	w.WriteByte('{')
+	for i, entry := range sli {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.Write(entry)
+	}
+	w.WriteByte('}')
 }
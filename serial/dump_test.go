@@ -0,0 +1,261 @@
+package serial
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+func TestDumpCanonical(t *testing.T) {
+	// values which used to collide
+	pairs := [][2]any{
+		{[2]any{`a","b`, ""}, [2]any{"a", "b"}},
+		{1e-9, 0.0},
+		{[1]any{int8(1)}, [1]any{1}},
+		{[1]any{"1"}, [1]any{1}},
+		{[]int(nil), []int{}},
+		{map[string]int(nil), map[string]int{}},
+		{float32(0.1), float32(0.10000001)},
+	}
+	for _, pair := range pairs {
+		a, b := String(pair[0], false), String(pair[1], false)
+		if a == b {
+			t.Errorf("%#v and %#v should not collide: %s", pair[0], pair[1], a)
+		}
+	}
+	AssertDump(t, "a\"b\n", `"a\"b\n"`)
+	AssertDump(t, 1e-9, `1e-09`)
+	AssertDump(t, [2]any{int8(1), "s"}, `[int8(1),string("s")]`)
+}
+
+func TestDumpRootType(t *testing.T) {
+	if got := String(1, false); got != "v3:int(1)" {
+		t.Errorf("unexpected dump %s", got)
+	}
+	if got := String([]*keyUser{nil}, false); got != "v3:[]*github.com/ahuigo/gofnext/serial.keyUser([null])" {
+		t.Errorf("unexpected dump %s", got)
+	}
+	if got := String(nil, false); got != "v3:<invalid>" {
+		t.Errorf("unexpected dump %s", got)
+	}
+	// root values of different types should not collide
+	values := []any{1, 1.0, int8(1), "1", uint(1), true, []int{1}, [1]int{1}}
+	seen := map[string]any{}
+	for _, v := range values {
+		s := String(v, false)
+		if prev, ok := seen[s]; ok {
+			t.Errorf("%#v and %#v should not collide: %s", prev, v, s)
+		}
+		seen[s] = v
+	}
+}
+
+// AssertDump checks the dump of val without the version and the type tag of root value
+func AssertDump(t *testing.T, val any, expected string) {
+	t.Helper()
+	if val != nil {
+		expected = typeString(reflect.TypeOf(val)) + "(" + expected + ")"
+	}
+	if got := String(val, false); got != versionPrefix+expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func FuzzDumpCollision(f *testing.F) {
+	f.Add(`a","b`, "", "a", "b", 1e-9, 0.0, int64(1), uint64(1))
+	f.Add("1", "", "", "1", 1.0, 1.0, int64(-1), uint64(0))
+	f.Fuzz(func(t *testing.T, s1, s2, s3, s4 string, f1, f2 float64, n int64, u uint64) {
+		if math.IsNaN(f1) || math.IsNaN(f2) {
+			return
+		}
+		values := []any{
+			[4]any{s1, s2, f1, n},
+			[4]any{s3, s4, f2, int64(u)},
+			[4]any{s1, s2, f1, u},
+			[]any{s1 + s2, f1},
+			[]any{s1, s2 + s3},
+			map[string]any{s1: f1, s2: n},
+			map[string]any{s3: f2, s4: u},
+			struct {
+				A string
+				B any
+			}{s1, s2},
+			struct {
+				A string
+				B any
+			}{s3, f2},
+		}
+		for i, a := range values {
+			for _, b := range values[i+1:] {
+				if reflect.DeepEqual(a, b) {
+					continue
+				}
+				if da, db := String(a, false), String(b, false); da == db {
+					t.Fatalf("%#v and %#v collide: %s", a, b, da)
+				}
+			}
+		}
+	})
+}
+
+func TestDumpSharedPointer(t *testing.T) {
+	type Config struct{ Name string }
+	type Req struct {
+		A, B *Config
+	}
+	// DAG: two fields point to one config
+	shared := &Config{Name: "a"}
+	AssertDump(t, Req{A: shared, B: shared}, `Req{A:&Config{Name:"a"},B:&Config{Name:"a"}}`)
+	if String(Req{A: shared, B: shared}, false) == String(Req{A: shared, B: &Config{Name: "b"}}, false) {
+		t.Fatal("keys which differ in the second reference should not collide")
+	}
+
+	// shared slices and maps
+	list := []int{1}
+	m := map[string]int{"k": 1}
+	AssertDump(t, [4]any{list, list, m, m}, `[[]int([1]),[]int([1]),map[string]int({"k":1}),map[string]int({"k":1})]`)
+}
+
+func TestDumpCycle(t *testing.T) {
+	type Node struct {
+		Name string
+		Next *Node
+	}
+	a := &Node{Name: "a"}
+	b := &Node{Name: "b", Next: a}
+	a.Next = b
+	AssertDump(t, a, `&Node{Name:"a",Next:&Node{Name:"b",Next:<cycle pointer ^2>}}`)
+	AssertDump(t, b, `&Node{Name:"b",Next:&Node{Name:"a",Next:<cycle pointer ^2>}}`)
+
+	// self reference
+	c := &Node{Name: "c"}
+	c.Next = c
+	AssertDump(t, c, `&Node{Name:"c",Next:<cycle pointer ^1>}`)
+
+	// cyclic slice with hashPtrAddr does not recurse forever
+	s := []any{nil}
+	s[0] = s
+	if got := String(s, true); got != `v3:[]interface {}([[]interface {}(<cycle slice ^1>)])` {
+		t.Fatalf("unexpected dump: %s", got)
+	}
+}
+
+func TestDumpTags(t *testing.T) {
+	type Request struct {
+		ID      int
+		TraceID string `gofnext:"-"`
+	}
+	type KeyRequest struct {
+		ID    int `gofnext:"key"`
+		Name  string
+		Inner Request `gofnext:"key"`
+	}
+	AssertDump(t, Request{ID: 1, TraceID: "t1"}, `Request{ID:1}`)
+	AssertDump(t, KeyRequest{ID: 1, Name: "n", Inner: Request{ID: 2, TraceID: "t2"}}, `KeyRequest{ID:1,Inner:Request{ID:2}}`)
+
+	if !HasKeyTag(reflect.TypeOf([1]KeyRequest{})) || !HasKeyTag(reflect.TypeOf(struct{ R Request }{})) || !HasKeyTag(reflect.TypeOf(&Request{})) {
+		t.Error("tagged structs should have key tags")
+	}
+	if HasKeyTag(reflect.TypeOf(struct{ ID int }{})) || HasKeyTag(reflect.TypeOf(&struct{ ID int }{})) {
+		t.Error("untagged structs and pointers should not have key tags")
+	}
+}
+
+type keyUser struct {
+	id   int
+	Name string
+}
+
+func (u *keyUser) CacheKey() string { return strconv.Itoa(u.id) }
+
+type keyBytes [2]int
+
+func (k keyBytes) CacheKey() []byte { return []byte{byte(k[0])} }
+
+func TestDumpCacheKeyer(t *testing.T) {
+	AssertDump(t, &keyUser{id: 1, Name: "a"}, `*github.com/ahuigo/gofnext/serial.keyUser#"1"`)
+	AssertDump(t, keyBytes{1, 2}, `github.com/ahuigo/gofnext/serial.keyBytes#"\x01"`)
+	type wrapper struct {
+		user  *keyUser
+		users []keyUser
+		any   any
+	}
+	w := wrapper{user: &keyUser{id: 2}, users: []keyUser{{id: 3}}, any: &keyUser{id: 4}}
+	AssertDump(t, w, `wrapper{user:*github.com/ahuigo/gofnext/serial.keyUser#"2",users:[keyUser{id:3,Name:""}],any:*github.com/ahuigo/gofnext/serial.keyUser(*github.com/ahuigo/gofnext/serial.keyUser#"4")}`)
+	AssertDump(t, (*keyUser)(nil), `null`)
+	if String(&keyUser{id: 1}, true) != String(&keyUser{id: 1}, true) {
+		t.Error("CacheKey should be used in place of pointer address")
+	}
+	if !HasCacheKeyer(reflect.TypeOf(w)) || HasCacheKeyer(reflect.TypeOf(keyUser{})) {
+		t.Error("HasCacheKeyer is wrong")
+	}
+}
+
+type keyNode struct {
+	Next *keyNode
+	Key  *struct{ K keyBytes }
+}
+
+func TestHasCacheKeyerNested(t *testing.T) {
+	// CacheKeyers held by pointers, slices and maps are found
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(struct{ P *struct{ K keyBytes } }{}),
+		reflect.TypeOf([]struct{ K keyBytes }{}),
+		reflect.TypeOf(map[string][1]keyBytes{}),
+		reflect.TypeOf(keyNode{}),
+	} {
+		if !HasCacheKeyer(typ) {
+			t.Errorf("%s should have CacheKeyer", typ)
+		}
+	}
+	type node struct{ Next *node }
+	if HasCacheKeyer(reflect.TypeOf(node{})) {
+		t.Error("recursive types without CacheKeyer should be supported")
+	}
+}
+
+func TestBytesErr(t *testing.T) {
+	var x int
+	if _, err := BytesErr([]any{1, unsafe.Pointer(&x)}, false); !errors.Is(err, ErrUnsupportedKind) {
+		t.Errorf("expected ErrUnsupportedKind, got %v", err)
+	}
+	if _, err := HashErr(map[string]any{"p": unsafe.Pointer(&x)}, nil); !errors.Is(err, ErrUnsupportedKind) {
+		t.Errorf("expected ErrUnsupportedKind, got %v", err)
+	}
+	if _, err := BytesErr((*panicKeyer)(&x), false); err == nil {
+		t.Error("panic of CacheKey should be returned as error")
+	}
+	if data, err := BytesErr([]int{1}, false); err != nil || string(data) != "v3:[]int([1])" {
+		t.Errorf("unexpected %s, %v", data, err)
+	}
+}
+
+type panicKeyer int
+
+func (p *panicKeyer) CacheKey() string { panic("no key") }
+
+type diamond struct{ L, R *diamond }
+
+func TestDumpTooManyRefs(t *testing.T) {
+	// shared nodes are dumped at every reference: 2^30 references are rejected rather than dumped
+	defer func(maxRefs int) { MaxRefs = maxRefs }(MaxRefs)
+	MaxRefs = 1 << 10
+	n := &diamond{}
+	for i := 0; i < 30; i++ {
+		n = &diamond{n, n}
+	}
+	if _, err := BytesErr(n, false); !errors.Is(err, ErrTooManyRefs) {
+		t.Errorf("expected ErrTooManyRefs, got %v", err)
+	}
+	if _, err := HashErr(n, nil); !errors.Is(err, ErrTooManyRefs) {
+		t.Errorf("expected ErrTooManyRefs, got %v", err)
+	}
+	// the limit is per dump
+	shared := &diamond{}
+	if _, err := BytesErr([]*diamond{shared, shared}, false); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,56 @@
+package serial
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"hash"
+	"reflect"
+	"sync"
+)
+
+// HashOptions configures Hash
+type HashOptions struct {
+	HashPtrAddr bool
+	// New creates the hash function(default: sha256.New), e.g. fnv.New128a for a faster non-crypto hash
+	New func() hash.Hash
+}
+
+var hashWriterPool = sync.Pool{
+	New: func() any { return bufio.NewWriterSize(nil, 4096) },
+}
+
+/*
+Hash digests the canonical dump of val(same as Bytes) in one walk, without building the dump.
+Digests shorter than 32 bytes are zero padded, and longer digests are truncated:
+
+	digest := serial.Hash(bigSlice, nil)
+*/
+func Hash(val any, opts *HashOptions) [32]byte {
+	digest, err := HashErr(val, opts)
+	if err != nil {
+		panic(err)
+	}
+	return digest
+}
+
+// HashErr is like Hash, but returns an error instead of panicking(e.g. ErrUnsupportedKind)
+func HashErr(val any, opts *HashOptions) (digest [32]byte, err error) {
+	if opts == nil {
+		opts = &HashOptions{}
+	}
+	newHash := opts.New
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	h := newHash()
+	w := hashWriterPool.Get().(*bufio.Writer)
+	defer hashWriterPool.Put(w)
+	w.Reset(h)
+	d := dumper{w: w, hashPtrAddr: opts.HashPtrAddr, ps: &PtrPath{}}
+	if err := d.dumpValue(reflect.ValueOf(val)); err != nil {
+		return digest, err
+	}
+	w.Flush()
+	copy(digest[:], h.Sum(nil))
+	return digest, nil
+}
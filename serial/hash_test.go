@@ -0,0 +1,61 @@
+package serial
+
+import (
+	"crypto/sha256"
+	"hash/fnv"
+	"testing"
+)
+
+type hashItem struct {
+	ID   int
+	Tags map[string]int
+	next *hashItem
+}
+
+func TestHashEqualsDump(t *testing.T) {
+	items := make([]hashItem, 1000)
+	for i := range items {
+		items[i] = hashItem{ID: i, Tags: map[string]int{"a": i, "b": -i}}
+	}
+	items[1].next = &items[2]
+	vals := []any{nil, 1, "s", items, map[any]any{1: "a", "b": 2.5}}
+	for _, val := range vals {
+		if Hash(val, nil) != sha256.Sum256(Bytes(val, false)) {
+			t.Errorf("hash of %T should be sha256 of its dump", val)
+		}
+	}
+
+	// 128-bit hash is zero padded
+	h := fnv.New128a()
+	h.Write(Bytes(items, false))
+	var expected [32]byte
+	copy(expected[:], h.Sum(nil))
+	if got := Hash(items, &HashOptions{New: fnv.New128a}); got != expected {
+		t.Errorf("expected %x, got %x", expected, got)
+	}
+}
+
+func TestHashCycle(t *testing.T) {
+	item := &hashItem{ID: 1}
+	item.next = item
+	if Hash(item, nil) != sha256.Sum256([]byte(String(item, false))) {
+		t.Error("hash of cycle should be sha256 of its dump")
+	}
+	if Hash(item, nil) == Hash(item, &HashOptions{HashPtrAddr: true}) {
+		t.Error("HashPtrAddr should change the digest")
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	items := make([]hashItem, 1000)
+	b.Run("Hash", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Hash(items, nil)
+		}
+	})
+	b.Run("Bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Bytes(items, false)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package serial
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+/*
+CacheKeyer is implemented by types with a natural identity(e.g. user ID),
+its CacheKey is dumped in place of its fields(or pointer address):
+
+	func (u *UserInfo) CacheKey() string { return strconv.Itoa(u.id) }
+*/
+type CacheKeyer interface {
+	CacheKey() string
+}
+
+// CacheKeyerBytes is the []byte version of CacheKeyer
+type CacheKeyerBytes interface {
+	CacheKey() []byte
+}
+
+var (
+	cacheKeyerType      = reflect.TypeOf((*CacheKeyer)(nil)).Elem()
+	cacheKeyerBytesType = reflect.TypeOf((*CacheKeyerBytes)(nil)).Elem()
+	cacheKeyerCache     sync.Map // reflect.Type -> bool
+)
+
+func isCacheKeyer(typ reflect.Type) bool {
+	if ok, found := cacheKeyerCache.Load(typ); found {
+		return ok.(bool)
+	}
+	ok := typ.Kind() != reflect.Interface && (typ.Implements(cacheKeyerType) || typ.Implements(cacheKeyerBytesType))
+	cacheKeyerCache.Store(typ, ok)
+	return ok
+}
+
+// cacheKeyOf calls CacheKey of refV, e.g. *UserInfo(1) is dumped as *example.com/pkg.UserInfo#"1".
+func cacheKeyOf(refV reflect.Value, buf []byte) ([]byte, bool) {
+	typ := refV.Type()
+	if !isCacheKeyer(typ) {
+		return nil, false
+	}
+	val, ok := interfaceOf(refV)
+	if !ok {
+		return nil, false
+	}
+	buf = append(buf, typeString(typ)...)
+	buf = append(buf, '#')
+	switch keyer := val.(type) {
+	case CacheKeyer:
+		return strconv.AppendQuote(buf, keyer.CacheKey()), true
+	case CacheKeyerBytes:
+		return strconv.AppendQuote(buf, string(keyer.CacheKey())), true
+	}
+	return nil, false
+}
+
+// HasCacheKeyer reports whether typ(or the types it holds, see holds) implements CacheKeyer
+func HasCacheKeyer(typ reflect.Type) bool {
+	return holds(typ, isCacheKeyer)
+}
@@ -6,20 +6,51 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
+/*
+Loader decodes the canonical dump(see Version) written by String/Bytes.
+Values which are dumped lossily cannot be loaded: pointer addresses(hashPtrAddr), CacheKeyer keys, normalized values,
+non-nil func and chan.
+*/
 type Loader struct {
-	d   []byte
-	pos int
+	d    []byte
+	pos  int
+	refs []reflect.Value // loaded references on the current path, the targets of cycle markers
 }
 
-func Load(data []byte, v any) error {
+/*
+Load decodes data dumped by String/Bytes into v, v should be a non-nil pointer.
+Values held by interfaces are restored with their type tags, named types should be registered by RegisterType
+(types used by v are registered automatically).
+*/
+func Load(data []byte, v any) (err error) {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return errors.New("non-nil pointer is required to load data into")
 	}
-
-	return (&Loader{d: data}).load(rv.Elem())
+	l := &Loader{d: data}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("serial.Load: %v", r)
+		}
+	}()
+	registerTypes(rv.Elem().Type())
+	if !l.hasPrefix(versionPrefix) {
+		l.fail("unsupported version(expected %s)", versionPrefix)
+	}
+	l.pos += len(versionPrefix)
+	if l.hasPrefix("<invalid>") {
+		l.pos += len("<invalid>")
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	} else {
+		l.loadTagged(rv.Elem(), 0)
+	}
+	if l.pos != len(l.d) {
+		l.fail("unexpected trailing data")
+	}
+	return nil
 }
 
 var _buf *bytes.Reader
@@ -29,116 +60,368 @@ func Tmpload(b []byte) error {
 	return err
 }
 
-func (l *Loader) loadFloat(start int, rv reflect.Value) (end int, err error) {
-	buf := []byte{}
-	if l.d[start] == '-' {
-		buf = []byte{'-'}
-		start++
+func (l *Loader) fail(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	panic(fmt.Sprintf("%s at offset %d", msg, l.pos))
+}
+
+func (l *Loader) hasPrefix(s string) bool {
+	return bytes.HasPrefix(l.d[l.pos:], []byte(s))
+}
+
+func (l *Loader) expect(s string) {
+	if !l.hasPrefix(s) {
+		l.fail("expected %q", s)
+	}
+	l.pos += len(s)
+}
+
+func (l *Loader) peek() byte {
+	if l.pos >= len(l.d) {
+		l.fail("unexpected end of data")
 	}
-	i := start
-	for ; i < len(l.d); i++ {
-		if (l.d[i] != '.' && l.d[i] < '0') || l.d[i] > '9' {
-			break
+	return l.d[l.pos]
+}
+
+// token reads a scalar token(number, bool, complex) until a delimiter
+func (l *Loader) token() string {
+	start := l.pos
+	if l.pos < len(l.d) && l.d[l.pos] == '(' {
+		// complex: (1+2i)
+		end := bytes.IndexByte(l.d[l.pos:], ')')
+		if end < 0 {
+			l.fail("unterminated complex")
 		}
-		buf = append(buf, l.d[i])
+		l.pos += end + 1
+		return string(l.d[start:l.pos])
+	}
+	for l.pos < len(l.d) && !strings.ContainsRune(",:)]}", rune(l.d[l.pos])) {
+		l.pos++
+	}
+	return string(l.d[start:l.pos])
+}
+
+func (l *Loader) loadString() string {
+	quoted, err := strconv.QuotedPrefix(string(l.d[l.pos:]))
+	if err != nil {
+		l.fail("invalid string")
 	}
-	f, err := strconv.ParseFloat(string(buf), 64)
+	s, err := strconv.Unquote(quoted)
 	if err != nil {
-		return -1, err
+		l.fail("invalid string: %v", err)
 	}
-	rv.SetFloat(f)
-	l.pos = i
-	return i, nil
+	l.pos += len(quoted)
+	return s
 }
 
-func (l *Loader) loadInt(start int, rv reflect.Value) (end int, err error) {
-	buf := []byte{}
-	if l.d[start] == '-' {
-		buf = []byte{'-'}
-		start++
+// loadCycle restores the marker(e.g. <cycle pointer ^2>) to the reference it refers to
+func (l *Loader) loadCycle(rv reflect.Value, kind string) bool {
+	prefix := "<cycle " + kind + " ^"
+	if !l.hasPrefix(prefix) {
+		return false
 	}
-	i := start
-	for ; i < len(l.d); i++ {
-		if l.d[i] < '0' || l.d[i] > '9' {
-			break
-		}
-		buf = append(buf, l.d[i])
+	l.pos += len(prefix)
+	up, err := strconv.Atoi(l.token0('>'))
+	l.expect(">")
+	if err != nil || up <= 0 || up > len(l.refs) {
+		l.fail("invalid cycle marker")
 	}
-	n, err := strconv.ParseInt(string(buf), 10, 64)
-	if err != nil {
-		return -1, err
-	}
-	rv.SetInt(n)
-	l.pos = i
-	return i, nil
-}
-
-func (l *Loader) loadString(start int, rv reflect.Value) (end int, err error) {
-	if l.d[start] != '"' {
-		return -1, fmt.Errorf("unterminated string: %s", l.d[start:])
-	}
-	buf := bytes.NewBuffer(nil)
-	data := l.d
-	start++
-	maxIndex := len(data) - 1
-	i := start
-	stop := false
-LOOP:
-	for ; i <= maxIndex; i++ {
-		switch data[i] {
-		case '\\':
-			i++
-			if i > maxIndex {
-				return -1, fmt.Errorf("unterminated string: %s", data[start:])
-			}
-			switch data[i] {
-			case '"', '\\', '/':
-				buf.WriteByte(data[i])
-			case 'b':
-				buf.WriteByte('\b')
-			case 't':
-				buf.WriteByte(0x09)
-			case 'n':
-				buf.WriteByte(0x0a)
-			case 'r':
-				buf.WriteByte(0x0d)
-			default:
-				buf.WriteByte(data[i])
-			}
-		case '"':
-			stop = true
-			i++
-			break LOOP
-		default:
-			buf.WriteByte(data[i])
-		}
+	ref := l.refs[len(l.refs)-up]
+	if !ref.Type().AssignableTo(rv.Type()) {
+		l.fail("cannot assign cycle %s to %s", ref.Type(), rv.Type())
+	}
+	rv.Set(ref)
+	return true
+}
+
+// token0 reads until the byte c
+func (l *Loader) token0(c byte) string {
+	end := bytes.IndexByte(l.d[l.pos:], c)
+	if end < 0 {
+		l.fail("expected %q", c)
 	}
-	if !stop {
-		return -1, fmt.Errorf("unterminated string: %s", data[start:])
+	s := string(l.d[l.pos : l.pos+end])
+	l.pos += end
+	return s
+}
+
+func (l *Loader) loadNull(rv reflect.Value) bool {
+	if l.hasPrefix("null") {
+		l.pos += len("null")
+		rv.Set(reflect.Zero(rv.Type()))
+		return true
 	}
-	rv.SetString(buf.String())
-	l.pos = i
-	return i, nil
+	return false
 }
 
-func (l *Loader) load(rv reflect.Value) error {
+func (l *Loader) load(rv reflect.Value, depth int) {
+	if depth > maxDepth {
+		l.fail("exceeded max depth")
+	}
+	rv = accessible(rv)
+	typ := rv.Type()
+	if l.hasPrefix(typeString(typ) + "#") {
+		l.fail("cannot load %s dumped by CacheKey or normalizer", typ)
+	}
 	switch rv.Kind() {
 	case reflect.String:
-		_, err := l.loadString(l.pos, rv)
+		rv.SetString(l.loadString())
+	case reflect.Bool:
+		b, err := strconv.ParseBool(l.token())
 		if err != nil {
-			return err
+			l.fail("invalid bool")
 		}
+		rv.SetBool(b)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		if _, err := l.loadInt(l.pos, rv); err != nil {
-			return err
+		n, err := strconv.ParseInt(l.token(), 10, typ.Bits())
+		if err != nil {
+			l.fail("invalid %s: %v", typ, err)
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(l.token(), 10, typ.Bits())
+		if err != nil {
+			l.fail("invalid %s: %v", typ, err)
 		}
+		rv.SetUint(n)
 	case reflect.Float32, reflect.Float64:
-		if _, err := l.loadFloat(l.pos, rv); err != nil {
-			return err
+		f, err := strconv.ParseFloat(l.token(), typ.Bits())
+		if err != nil {
+			l.fail("invalid %s: %v", typ, err)
+		}
+		rv.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		c, err := strconv.ParseComplex(l.token(), typ.Bits())
+		if err != nil {
+			l.fail("invalid %s: %v", typ, err)
+		}
+		rv.SetComplex(c)
+	case reflect.Ptr:
+		if l.loadNull(rv) || l.loadCycle(rv, "pointer") {
+			return
+		}
+		if l.hasPrefix("*0x") {
+			l.fail("cannot load pointer address")
+		}
+		l.expect("&")
+		ptr := reflect.New(typ.Elem())
+		rv.Set(ptr)
+		l.refs = append(l.refs, ptr)
+		l.load(ptr.Elem(), depth+1)
+		l.refs = l.refs[:len(l.refs)-1]
+	case reflect.Interface:
+		if l.loadNull(rv) {
+			return
+		}
+		l.loadTagged(rv, depth)
+	case reflect.Slice:
+		if l.loadNull(rv) || l.loadCycle(rv, "slice") {
+			return
+		}
+		n := l.countElems()
+		sli := reflect.MakeSlice(typ, n, n)
+		rv.Set(sli)
+		l.refs = append(l.refs, sli)
+		l.loadElems(sli, depth)
+		l.refs = l.refs[:len(l.refs)-1]
+	case reflect.Array:
+		if n := l.countElems(); n != rv.Len() {
+			l.fail("cannot load %d elements into %s", n, typ)
+		}
+		l.loadElems(rv, depth)
+	case reflect.Map:
+		if l.loadNull(rv) || l.loadCycle(rv, "map") {
+			return
+		}
+		m := reflect.MakeMap(typ)
+		rv.Set(m)
+		l.refs = append(l.refs, m)
+		l.loadMap(m, depth)
+		l.refs = l.refs[:len(l.refs)-1]
+	case reflect.Struct:
+		l.loadStruct(rv, depth)
+	case reflect.Func, reflect.Chan:
+		if !l.loadNull(rv) {
+			l.fail("cannot load non-nil %s", rv.Kind())
 		}
 	default:
-		return fmt.Errorf("unsupported kind %s", rv.Kind())
+		l.fail("unsupported kind %s", rv.Kind())
 	}
+}
 
-	return nil
+// countElems counts the elements of [a,b,...] without loading them
+func (l *Loader) countElems() int {
+	pos := l.pos
+	defer func() { l.pos = pos }()
+	l.expect("[")
+	if l.peek() == ']' {
+		return 0
+	}
+	n := 0
+	for {
+		l.skip()
+		n++
+		if l.peek() == ']' {
+			return n
+		}
+		l.expect(",")
+	}
+}
+
+func (l *Loader) loadElems(rv reflect.Value, depth int) {
+	l.expect("[")
+	for i := 0; i < rv.Len(); i++ {
+		if i > 0 {
+			l.expect(",")
+		}
+		l.load(rv.Index(i), depth+1)
+	}
+	l.expect("]")
+}
+
+func (l *Loader) loadMap(m reflect.Value, depth int) {
+	typ := m.Type()
+	l.expect("{")
+	for i := 0; l.peek() != '}'; i++ {
+		if i > 0 {
+			l.expect(",")
+		}
+		key := reflect.New(typ.Key()).Elem()
+		l.load(key, depth+1)
+		l.expect(":")
+		val := reflect.New(typ.Elem()).Elem()
+		l.load(val, depth+1)
+		m.SetMapIndex(key, val)
+	}
+	l.expect("}")
+}
+
+// loadStruct loads Name{Field:value,...}, fields which are not dumped(e.g. excluded by tags) are left zero
+func (l *Loader) loadStruct(rv reflect.Value, depth int) {
+	typ := rv.Type()
+	l.expect(typ.Name() + "{")
+	for i := 0; l.peek() != '}'; i++ {
+		if i > 0 {
+			l.expect(",")
+		}
+		name := l.token0(':')
+		l.expect(":")
+		field, ok := typ.FieldByName(name)
+		if !ok || len(field.Index) != 1 {
+			l.fail("unknown field %s of %s", name, typ)
+		}
+		l.load(rv.Field(field.Index[0]), depth+1)
+	}
+	l.expect("}")
+}
+
+// loadTagged loads the root value or the value held by interface: Type(value)
+func (l *Loader) loadTagged(rv reflect.Value, depth int) {
+	end := bytes.IndexByte(l.d[l.pos:], '(')
+	if end < 0 {
+		l.fail("expected type tag")
+	}
+	name := string(l.d[l.pos : l.pos+end])
+	t := rv.Type()
+	if name != typeString(t) {
+		t = parseType(name)
+	}
+	if t == nil {
+		l.fail("unregistered type %s(see serial.RegisterType)", name)
+	}
+	if !t.AssignableTo(rv.Type()) {
+		l.fail("cannot assign %s to %s", t, rv.Type())
+	}
+	l.pos += end + 1
+	val := reflect.New(t).Elem()
+	l.load(val, depth+1)
+	l.expect(")")
+	rv.Set(val)
+}
+
+// skip skips a value
+func (l *Loader) skip() {
+	depth := 0
+	for l.pos < len(l.d) {
+		switch c := l.d[l.pos]; c {
+		case '"':
+			l.loadString()
+			continue
+		case '(', '[', '{', '<':
+			depth++
+		case ')', ']', '}', '>':
+			if depth == 0 {
+				return
+			}
+			depth--
+		case ',', ':':
+			if depth == 0 {
+				return
+			}
+		}
+		l.pos++
+	}
+}
+
+// parseType parses the type name written by typeString, e.g. map[string][]*github.com/pkg.User
+func parseType(name string) reflect.Type {
+	t, rest := parseTypePrefix(name)
+	if rest != "" {
+		return nil
+	}
+	return t
+}
+
+func parseTypePrefix(name string) (reflect.Type, string) {
+	switch {
+	case strings.HasPrefix(name, "*"):
+		t, rest := parseTypePrefix(name[1:])
+		if t == nil {
+			return nil, ""
+		}
+		return reflect.PointerTo(t), rest
+	case strings.HasPrefix(name, "[]"):
+		t, rest := parseTypePrefix(name[2:])
+		if t == nil {
+			return nil, ""
+		}
+		return reflect.SliceOf(t), rest
+	case strings.HasPrefix(name, "["):
+		n, rest, ok := strings.Cut(name[1:], "]")
+		size, err := strconv.Atoi(n)
+		if !ok || err != nil {
+			return nil, ""
+		}
+		t, rest := parseTypePrefix(rest)
+		if t == nil {
+			return nil, ""
+		}
+		return reflect.ArrayOf(size, t), rest
+	case strings.HasPrefix(name, "map["):
+		key, rest := parseTypePrefix(name[4:])
+		if key == nil || !strings.HasPrefix(rest, "]") {
+			return nil, ""
+		}
+		elem, rest := parseTypePrefix(rest[1:])
+		if elem == nil {
+			return nil, ""
+		}
+		return reflect.MapOf(key, elem), rest
+	case strings.HasPrefix(name, "interface {}"):
+		return basicTypes[reflect.Interface], name[len("interface {}"):]
+	}
+	end := strings.IndexAny(name, "]")
+	if end < 0 {
+		end = len(name)
+	}
+	ident, rest := name[:end], name[end:]
+	for _, t := range basicTypes {
+		if t.String() == ident {
+			return t, rest
+		}
+	}
+	if t, ok := typeRegistry.Load(ident); ok {
+		return t.(reflect.Type), rest
+	}
+	return nil, rest
 }
@@ -1,8 +1,11 @@
 package serial
 
 import (
+	"bytes"
 	"math"
+	"reflect"
 	"testing"
+	"testing/quick"
 )
 
 const float64EqualityThreshold = 1e-9
@@ -13,14 +16,14 @@ func almostEqual(a, b float64) bool {
 
 func TestLoadBase(t *testing.T) {
 	var f float64
-	Load([]byte(`-3.14`), &f)
+	Load([]byte(`v3:float64(-3.14)`), &f)
 	expectedFloat := -3.14
 	if !almostEqual(f, expectedFloat) {
 		t.Errorf("got %f, want %f", f, expectedFloat)
 	}
 
 	var str string
-	data := []byte(`"Hello, World!chars:\"\r\n\t\b"`)
+	data := []byte(`v3:string("Hello, World!chars:\"\r\n\t\b")`)
 	_ = Load(data, &str)
 	expected := "Hello, World!chars:\"\r\n\t\b"
 	if str != expected {
@@ -28,10 +31,133 @@ func TestLoadBase(t *testing.T) {
 	}
 
 	var i int
-	Load([]byte(`-42`), &i)
+	Load([]byte(`v3:int(-42)`), &i)
 	expectedInt := -42
 	if i != expectedInt {
 		t.Errorf("got %d, want %d", i, expectedInt)
 	}
 
 }
+
+type loadItem struct {
+	B    bool
+	I8   int8
+	U    uint
+	U64  uint64
+	F32  float32
+	F64  float64
+	C64  complex64
+	C128 complex128
+	S    string
+	P    *int
+	Sli  []string
+	Arr  [2]int16
+	M    map[string]int
+	MP   map[int]*string
+	Sub  loadSub
+	Subs []loadSub
+}
+
+type loadSub struct {
+	Name string
+	N    uint8
+}
+
+func TestLoadRoundTrip(t *testing.T) {
+	f := func(item loadItem) bool {
+		dumped := Bytes(item, false)
+		var loaded loadItem
+		if err := Load(dumped, &loaded); err != nil {
+			t.Errorf("load %s: %v", dumped, err)
+			return false
+		}
+		if !reflect.DeepEqual(item, loaded) {
+			t.Errorf("expected %#v, got %#v", item, loaded)
+			return false
+		}
+		return bytes.Equal(dumped, Bytes(loaded, false))
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+type loadNode struct {
+	id       int
+	name     *string
+	children []*loadNode
+	attrs    map[string]any
+	value    any
+}
+
+func TestLoadPrivateAndInterface(t *testing.T) {
+	RegisterType(loadSub{})
+	name := "root"
+	node := &loadNode{
+		id:       1,
+		name:     &name,
+		children: []*loadNode{{id: 2}, nil},
+		attrs:    map[string]any{"a": int8(1), "b": []any{"x", 2.5, nil}, "c": loadSub{Name: "s"}, "d": &name},
+		value:    map[int]string{1: "1"},
+	}
+	dumped := Bytes(node, false)
+	var loaded *loadNode
+	if err := Load(dumped, &loaded); err != nil {
+		t.Fatalf("load %s: %v", dumped, err)
+	}
+	if !reflect.DeepEqual(node, loaded) {
+		t.Errorf("expected %s, got %s", dumped, String(loaded, false))
+	}
+
+	var untyped any
+	for _, val := range []any{"s", 1, 2.5, true, nil, complex(1, 2)} {
+		if err := Load(Bytes(val, false), &untyped); err != nil || untyped != val {
+			t.Errorf("expected %v, got %v(%v)", val, untyped, err)
+		}
+	}
+}
+
+func TestLoadCycle(t *testing.T) {
+	node := &loadNode{id: 1}
+	node.children = []*loadNode{node}
+	node.attrs = map[string]any{}
+	node.attrs["self"] = node.attrs
+	dumped := Bytes(node, false)
+	var loaded *loadNode
+	if err := Load(dumped, &loaded); err != nil {
+		t.Fatalf("load %s: %v", dumped, err)
+	}
+	if loaded.children[0] != loaded || reflect.ValueOf(loaded.attrs["self"]).Pointer() != reflect.ValueOf(loaded.attrs).Pointer() {
+		t.Errorf("cycles should be restored: %s", String(loaded, false))
+	}
+	if got := String(loaded, false); got != string(dumped) {
+		t.Errorf("expected %s, got %s", dumped, got)
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	var i int
+	var p *int
+	var u *keyUser
+	var s []int
+	errCases := []struct {
+		data string
+		v    any
+	}{
+		{`v3:int(1.5)`, &i},
+		{`v3:int(1),`, &i},
+		{`v2:int(1)`, &i},
+		{`1`, &i},
+		{`v3:int8(1)`, &i},
+		{`v3:*int(*0xc000012345)`, &p},
+		{`v3:*github.com/ahuigo/gofnext/serial.keyUser(*github.com/ahuigo/gofnext/serial.keyUser#"1")`, &u},
+		{`v3:[]int([1,"a"])`, &s},
+		{`v3:[]int(<cycle slice ^1>)`, &s},
+		{`v3:unknown.Type(1)`, new(any)},
+	}
+	for _, c := range errCases {
+		if err := Load([]byte(c.data), c.v); err == nil {
+			t.Errorf("load %s should fail", c.data)
+		}
+	}
+}
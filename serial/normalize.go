@@ -0,0 +1,89 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var normalizers sync.Map // reflect.Type -> func(any) any
+
+/*
+RegisterNormalizer registers a normalizer of type T, values of T are dumped as their normalized values,
+so that equal values with different representations derive the same key:
+
+	serial.RegisterNormalizer(func(d decimal.Decimal) any { return d.String() })
+
+Well-known types are normalized by default:
+  - time.Time: UTC with nanoseconds(location and monotonic reading are ignored)
+  - *big.Int, *big.Float and *big.Rat: by value
+  - net.IP, netip.Addr and netip.Prefix: by their normalized text
+  - json.RawMessage: compact json with sorted keys
+*/
+func RegisterNormalizer[T any](normalize func(v T) any) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	normalizers.Store(typ, func(v any) any { return normalize(v.(T)) })
+}
+
+func getNormalizer(typ reflect.Type) func(any) any {
+	if normalize, ok := normalizers.Load(typ); ok {
+		return normalize.(func(any) any)
+	}
+	return nil
+}
+
+// HasNormalizer reports whether typ(or the types it holds, see holds) has a normalizer
+func HasNormalizer(typ reflect.Type) bool {
+	return holds(typ, func(t reflect.Type) bool { return getNormalizer(t) != nil })
+}
+
+func init() {
+	RegisterNormalizer(func(t time.Time) any { return t.UTC().Format(time.RFC3339Nano) })
+	RegisterNormalizer(func(n *big.Int) any { return n.String() })
+	// 'p' format is exact whatever the precision is
+	RegisterNormalizer(func(f *big.Float) any { return f.Text('p', 0) })
+	RegisterNormalizer(func(r *big.Rat) any { return r.RatString() })
+	RegisterNormalizer(func(ip net.IP) any { return ip.String() })
+	RegisterNormalizer(func(addr netip.Addr) any { return addr.String() })
+	RegisterNormalizer(func(prefix netip.Prefix) any { return prefix.String() })
+	RegisterNormalizer(func(msg json.RawMessage) any {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		dec.UseNumber()
+		if dec.Decode(&v) != nil || dec.More() {
+			// invalid json
+			return string(msg)
+		}
+		data, _ := json.Marshal(v)
+		return string(data)
+	})
+}
+
+// interfaceOf returns refV as interface, values of unexported fields are accessed by pointer or address
+func interfaceOf(refV reflect.Value) (any, bool) {
+	if refV.CanInterface() {
+		return refV.Interface(), true
+	}
+	if refV.Kind() == reflect.Ptr {
+		return reflect.NewAt(refV.Type().Elem(), refV.UnsafePointer()).Interface(), true
+	}
+	if refV.CanAddr() {
+		return accessible(refV).Interface(), true
+	}
+	return nil, false
+}
+
+// exposed copies struct and array values which are not addressable(e.g. map's elements),
+// so that their unexported fields are accessible
+func exposed(refV reflect.Value) reflect.Value {
+	switch refV.Kind() {
+	case reflect.Struct, reflect.Array:
+		return addressable(refV)
+	}
+	return refV
+}
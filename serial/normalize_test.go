@@ -0,0 +1,60 @@
+package serial
+
+import (
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type normalizeEvent struct {
+	at   time.Time
+	Addr net.IP
+}
+
+func TestNormalizeWellKnown(t *testing.T) {
+	utc := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	local := utc.In(time.FixedZone("X", 3600))
+	now := time.Now()
+	pairs := [][2]any{
+		{utc, local},
+		{now.Round(0).Truncate(time.Second), now.Truncate(time.Second)},
+		{normalizeEvent{at: utc}, normalizeEvent{at: local}},
+		{big.NewInt(42), new(big.Int).SetBytes([]byte{42})},
+		{new(big.Float).SetPrec(10).SetInt64(3), new(big.Float).SetPrec(100).SetInt64(3)},
+		{big.NewRat(2, 4), big.NewRat(1, 2)},
+		{net.IPv4(1, 2, 3, 4), net.IP{1, 2, 3, 4}},
+		{map[string]net.IP{"a": net.IPv4(1, 2, 3, 4)}, map[string]net.IP{"a": {1, 2, 3, 4}}},
+		{json.RawMessage(`{"b": 1, "a": [1.0]}`), json.RawMessage(`{"a":[1.0],"b":1}`)},
+	}
+	for _, pair := range pairs {
+		a, b := String(pair[0], false), String(pair[1], false)
+		if a != b {
+			t.Errorf("%v and %v should be normalized: %s != %s", pair[0], pair[1], a, b)
+		}
+	}
+	AssertDump(t, utc, `time.Time#"2024-01-02T03:04:05.000000006Z"`)
+	AssertDump(t, big.NewInt(-1), `*math/big.Int#"-1"`)
+	AssertDump(t, netip.MustParseAddr("::1"), `net/netip.Addr#"::1"`)
+	AssertDump(t, (*big.Int)(nil), `null`)
+	if String(big.NewInt(1), false) == String(big.NewInt(2), false) {
+		t.Error("different values should not collide")
+	}
+}
+
+type celsius struct{ deg float64 }
+
+func TestRegisterNormalizer(t *testing.T) {
+	RegisterNormalizer(func(c celsius) any { return int(c.deg) })
+	AssertDump(t, []celsius{{1.2}, {1.7}}, `[github.com/ahuigo/gofnext/serial.celsius#1,github.com/ahuigo/gofnext/serial.celsius#1]`)
+	if !HasNormalizer(typeOf[struct{ C [2]celsius }]()) || !HasNormalizer(typeOf[*celsius]()) || HasNormalizer(typeOf[*struct{ C float64 }]()) {
+		t.Error("HasNormalizer is wrong")
+	}
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
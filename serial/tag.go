@@ -0,0 +1,89 @@
+package serial
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+TagName is the struct tag which controls how structs are dumped for cache keys:
+  - `gofnext:"-"` excludes the field(e.g. trace IDs, loggers)
+  - `gofnext:"key"` includes only the tagged fields of the struct
+*/
+const TagName = "gofnext"
+
+type structInfo struct {
+	fields []int // indexes of dumped fields
+	hasTag bool  // some fields are excluded
+}
+
+var structInfoCache sync.Map // reflect.Type -> *structInfo
+
+func getStructInfo(typ reflect.Type) *structInfo {
+	if info, ok := structInfoCache.Load(typ); ok {
+		return info.(*structInfo)
+	}
+	info := &structInfo{}
+	hasKey := false
+	for i := 0; i < typ.NumField(); i++ {
+		if tagOf(typ.Field(i)) == "key" {
+			hasKey = true
+			break
+		}
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		tag := tagOf(typ.Field(i))
+		if tag == "-" || (hasKey && tag != "key") {
+			info.hasTag = true
+			continue
+		}
+		info.fields = append(info.fields, i)
+	}
+	structInfoCache.Store(typ, info)
+	return info
+}
+
+func tagOf(field reflect.StructField) string {
+	tag, _, _ := strings.Cut(field.Tag.Get(TagName), ",")
+	return tag
+}
+
+/*
+HasKeyTag reports whether values of typ(or the types it holds, see holds) have fields excluded by TagName,
+such values should be dumped rather than compared by ==.
+*/
+func HasKeyTag(typ reflect.Type) bool {
+	return holds(typ, func(t reflect.Type) bool {
+		return t.Kind() == reflect.Struct && getStructInfo(t).hasTag
+	})
+}
+
+// holds reports whether typ(or the types it holds by value, pointer, slice or map) matches pred
+func holds(typ reflect.Type, pred func(reflect.Type) bool) bool {
+	return holdsSeen(typ, pred, map[reflect.Type]bool{})
+}
+
+func holdsSeen(typ reflect.Type, pred func(reflect.Type) bool, seen map[reflect.Type]bool) bool {
+	if typ == nil || seen[typ] {
+		return false
+	}
+	// recursive types(e.g. linked lists) are visited once
+	seen[typ] = true
+	if pred(typ) {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Array, reflect.Pointer, reflect.Slice:
+		return holdsSeen(typ.Elem(), pred, seen)
+	case reflect.Map:
+		return holdsSeen(typ.Key(), pred, seen) || holdsSeen(typ.Elem(), pred, seen)
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if holdsSeen(typ.Field(i).Type, pred, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}